@@ -1,39 +1,110 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
 
 	"github.com/manifoldco/promptui"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/api"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/commands"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
 )
 
 var serverURL = os.Getenv("SERVER_URL")
+var hashAlgorithm = os.Getenv("HASH_ALGORITHM")
+var workersEnv = os.Getenv("WORKERS")
+var chunkSizeEnv = os.Getenv("CHUNK_SIZE")
+var oddNodeStrategy = os.Getenv("ODD_NODE_STRATEGY")
+var leafEncoding = os.Getenv("LEAF_ENCODING")
+var uploadWorkersEnv = os.Getenv("UPLOAD_WORKERS")
 
 func main() {
 	if serverURL == "" {
 		serverURL = "http://localhost:8080"
 	}
 
+	hasher, err := merkletree.HasherByName(hashAlgorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workers := runtime.NumCPU()
+	if workersEnv != "" {
+		workers, err = strconv.Atoi(workersEnv)
+		if err != nil || workers < 1 {
+			log.Fatal("WORKERS must be a positive integer")
+		}
+	}
+
+	uploadWorkers := 4
+	if uploadWorkersEnv != "" {
+		uploadWorkers, err = strconv.Atoi(uploadWorkersEnv)
+		if err != nil || uploadWorkers < 1 {
+			log.Fatal("UPLOAD_WORKERS must be a positive integer")
+		}
+	}
+
+	chunkSize := 0
+	if chunkSizeEnv != "" {
+		chunkSize, err = strconv.Atoi(chunkSizeEnv)
+		if err != nil || chunkSize < 0 {
+			log.Fatal("CHUNK_SIZE must be a non-negative integer")
+		}
+	}
+
+	treeConfig := commands.TreeConfig{
+		Hasher:       hasher,
+		Workers:      workers,
+		ChunkSize:    chunkSize,
+		OddNode:      merkletree.OddNodeStrategy(oddNodeStrategy),
+		LeafEncoding: merkletree.LeafEncoding(leafEncoding),
+	}
+	if treeConfig.OddNode == "" {
+		treeConfig.OddNode = merkletree.OddNodeDuplicate
+	}
+	if treeConfig.LeafEncoding == "" {
+		treeConfig.LeafEncoding = merkletree.LeafEncodingContent
+	}
+
+	client := api.NewClient(serverURL)
+
 	// To wake up the server (it sleeps when inactive)
-	go api.Ping(serverURL)
+	go client.Ping(context.Background())
 
 	const createFilesCmdText = "Create Test Files"
 	const createTreeCmdText = "Generate Merkle Tree"
+	const refreshTreeCmdText = "Refresh Merkle Tree"
 	const uploadFilesCmdText = "Upload Test Files"
+	const uploadDedupFilesCmdText = "Upload Test Files with Deduplication"
+	const resumeUploadCmdText = "Resume Upload"
 	const deleteTestFilesCmdText = "Delete Local Test Files"
 	const deleteDownloadCmdText = "Delete Downloads"
 	const downloadAndVerifyFileCmdText = "Download and Verify File"
+	const downloadAndVerifyFilesCmdText = "Download and Verify Multiple Files"
+	const downloadAndVerifyChunksCmdText = "Download and Verify File in Chunks"
+	const checkConsistencyCmdText = "Check Server Consistency"
+	const compareWithServerCmdText = "Compare with Server"
+	const verifyFileDeletedCmdText = "Verify File Deleted on Server"
 	const corruptFileCmdText = "Corrupt a File on Server"
 	const exitCmdText = "Exit"
 
 	items := []string{
 		createFilesCmdText,
 		createTreeCmdText,
+		refreshTreeCmdText,
 		uploadFilesCmdText,
+		uploadDedupFilesCmdText,
+		resumeUploadCmdText,
 		downloadAndVerifyFileCmdText,
+		downloadAndVerifyFilesCmdText,
+		downloadAndVerifyChunksCmdText,
+		checkConsistencyCmdText,
+		compareWithServerCmdText,
+		verifyFileDeletedCmdText,
 		corruptFileCmdText,
 		deleteTestFilesCmdText,
 		deleteDownloadCmdText,
@@ -46,6 +117,8 @@ func main() {
 		Size:  len(items),
 	}
 
+	tree := commands.LoadTreeCmd()
+
 	for {
 		_, selected, err := prompt.Run()
 		if err != nil {
@@ -56,17 +129,35 @@ func main() {
 		case createFilesCmdText:
 			commands.CreateFilesCmd()
 		case createTreeCmdText:
-			commands.CreateTreeCmd()
+			if newTree := commands.CreateTreeCmd(treeConfig); newTree != nil {
+				tree = newTree
+			}
+		case refreshTreeCmdText:
+			commands.RefreshTreeCmd(tree, treeConfig.Workers)
 		case uploadFilesCmdText:
-			commands.UploadFilesCmd(serverURL)
+			commands.UploadFilesCmd(client, hasher, false, uploadWorkers)
+		case uploadDedupFilesCmdText:
+			commands.UploadFilesCmd(client, hasher, true, uploadWorkers)
+		case resumeUploadCmdText:
+			commands.ResumeUploadCmd(client, hasher, uploadWorkers)
 		case deleteTestFilesCmdText:
 			commands.DeleteTestFilesCmd()
 		case deleteDownloadCmdText:
 			commands.DeleteDownloadsCmd()
 		case downloadAndVerifyFileCmdText:
-			commands.DownloadAndVerifyFileCmd(serverURL)
+			commands.DownloadAndVerifyFileCmd(client, tree)
+		case downloadAndVerifyFilesCmdText:
+			commands.DownloadAndVerifyFilesCmd(client, tree)
+		case downloadAndVerifyChunksCmdText:
+			commands.DownloadAndVerifyChunksCmd(client, tree)
+		case checkConsistencyCmdText:
+			commands.CheckConsistencyCmd(client, tree)
+		case compareWithServerCmdText:
+			commands.CompareWithServerCmd(client, tree)
+		case verifyFileDeletedCmdText:
+			commands.VerifyFileDeletedCmd(client, hasher)
 		case corruptFileCmdText:
-			commands.CorruptFileCmd(serverURL)
+			commands.CorruptFileCmd(client)
 		case exitCmdText:
 			commands.ExitCmd()
 		}
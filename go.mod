@@ -5,9 +5,10 @@ go 1.22.1
 require (
 	github.com/google/uuid v1.6.0
 	github.com/manifoldco/promptui v0.9.0
+	golang.org/x/crypto v0.31.0
 )
 
 require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
-	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -2,168 +2,521 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"net/http"
-	"time"
+	neturl "net/url"
+	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/chunker"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
 )
 
-func UploadFiles(url string, files []fileutil.File, ch chan<- int) error {
-	const defaultBatchSize = 4000
-	const retryInterval = 100 * time.Millisecond
-	const retryTimeout = 30 * time.Second
+const (
+	uploadEndpoint         = "/files/upload-batch"
+	uploadManifestEndpoint = "/files/upload-manifest-batch"
+)
 
-	batchId, err := uuid.NewV7()
+// UploadFiles only reads the batches it is sending from files, not every file
+// at once
+func (c *Client) UploadFiles(ctx context.Context, files fileutil.FileSource, ch chan<- int, opts ...UploadOption) error {
+	config := newUploadConfig(opts)
+	journal, err := newUploadJournal(uploadEndpoint, files.Len(), nil, config.journalPath)
 	if err != nil {
 		return err
 	}
+	return c.uploadBatches(ctx, journal, files, ch, config.parallelism, encodeFiles)
+}
 
-	requestUrl := fmt.Sprintf("%s/files/upload-batch/%s", url, batchId)
-	currentBatchSize := defaultBatchSize
-	for i := 0; i < len(files); i += currentBatchSize {
-		currentBatchSize = defaultBatchSize
-		attemptCount := 0
-		start := time.Now()
-		elapsed := 0 * time.Second
+func encodeFiles(batch []fileutil.File) ([]byte, error) {
+	return json.Marshal(batch)
+}
 
-		for elapsed < retryTimeout {
-			end := i + currentBatchSize
-			if end >= len(files) {
-				requestUrl = fmt.Sprintf("%s?batch-complete=%t", requestUrl, true)
-				end = len(files)
-			}
+// ResumeUpload carries on the upload recorded in the journal at path, only
+// sending the files the server hasn't taken. The files must be the ones the upload
+// started with. Progress is journaled back to path, whatever journal opts
+// ask for.
+func (c *Client) ResumeUpload(ctx context.Context, files fileutil.FileSource, path string, ch chan<- int, opts ...UploadOption) (UploadStats, error) {
+	config := newUploadConfig(opts)
+	journal, err := LoadUploadJournal(path)
+	if err != nil {
+		return UploadStats{}, err
+	}
+	if journal.IsComplete() {
+		return UploadStats{}, errors.New("upload is already complete")
+	}
+	err = journal.Verify(files)
+	if err != nil {
+		return UploadStats{}, err
+	}
 
-			batch := files[i:end]
+	switch journal.Endpoint {
+	case uploadEndpoint:
+		return UploadStats{}, c.uploadBatches(ctx, journal, files, ch, config.parallelism, encodeFiles)
+	case uploadManifestEndpoint:
+		if journal.Chunker == nil {
+			return UploadStats{}, errors.New("invalid upload journal: deduplicated upload without chunker config")
+		}
+		return c.uploadDeduplicated(ctx, journal, files, *journal.Chunker, ch, config.parallelism)
+	}
+	return UploadStats{}, fmt.Errorf("invalid upload journal: unknown endpoint %s", journal.Endpoint)
+}
+
+// newUploadJournal starts a journal for a new upload under a new batch id,
+// saving it straight away unless path is empty
+func newUploadJournal(endpoint string, fileCount int, config *chunker.Config, path string) (*UploadJournal, error) {
+	batchId, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &UploadJournal{
+		BatchID:   batchId.String(),
+		Endpoint:  endpoint,
+		Chunker:   config,
+		FileCount: fileCount,
+		path:      path,
+	}
+	return journal, journal.save()
+}
+
+// UploadStats counts what a deduplicated upload sent compared to the size of
+// the files
+type UploadStats struct {
+	Chunks         int
+	Bytes          int64
+	UploadedChunks int
+	UploadedBytes  int64
+}
 
-			jsonData, err := json.Marshal(batch)
+// fileManifest is a file sent as the SHA-256 hashes of its chunks, which the
+// server joins back together
+type fileManifest struct {
+	Name   string
+	Size   int64
+	Mode   fs.FileMode `json:",omitempty"`
+	Chunks [][]byte
+	// Root is the SHA-256 Merkle root of Chunks from chunker.Root, for the
+	// server to check the joined file against
+	Root []byte
+}
+
+type chunkUpload struct {
+	Hash []byte
+	Data []byte
+}
+
+// UploadFilesDeduplicated splits files into content-defined chunks and only
+// uploads the chunks the server doesn't already have, so content shared
+// between files or with an earlier upload is sent once. Chunks are addressed
+// by SHA-256 whatever hash algorithm the tree uses.
+func (c *Client) UploadFilesDeduplicated(ctx context.Context, files fileutil.FileSource, config chunker.Config, ch chan<- int, opts ...UploadOption) (UploadStats, error) {
+	uploadConfig := newUploadConfig(opts)
+	journal, err := newUploadJournal(uploadManifestEndpoint, files.Len(), &config, uploadConfig.journalPath)
+	if err != nil {
+		return UploadStats{}, err
+	}
+	return c.uploadDeduplicated(ctx, journal, files, config, ch, uploadConfig.parallelism)
+}
+
+// uploadDeduplicated may encode several batches at once, so a chunk shared by
+// batches in flight together can be sent more than once
+func (c *Client) uploadDeduplicated(ctx context.Context, journal *UploadJournal, files fileutil.FileSource, config chunker.Config, ch chan<- int, parallelism int) (UploadStats, error) {
+	var mu sync.Mutex
+	var stats UploadStats
+	uploaded := make(map[string]bool)
+	// A batch is split again each time it is retried, so only count each file once
+	counted := make(map[string]bool)
+
+	err := c.uploadBatches(ctx, journal, files, ch, parallelism, func(batch []fileutil.File) ([]byte, error) {
+		var manifests []fileManifest
+		chunks := make(map[string][]byte)
+		for _, file := range batch {
+			manifest := fileManifest{Name: file.Name, Size: int64(len(file.Data)), Mode: file.Mode}
+			root, err := chunker.SplitRoot(bytes.NewReader(file.Data), config, func(chunk []byte, hash []byte) error {
+				manifest.Chunks = append(manifest.Chunks, hash)
+				mu.Lock()
+				defer mu.Unlock()
+				if !uploaded[string(hash)] {
+					chunks[string(hash)] = append([]byte{}, chunk...)
+				}
+				return nil
+			}, merkletree.WithHasher(merkletree.SHA256))
 			if err != nil {
-				return err
+				return nil, err
 			}
-			res, err := http.Post(requestUrl, "application/json", bytes.NewBuffer(jsonData))
-			if err != nil {
-				return err
+			manifest.Root = root
+			mu.Lock()
+			if !counted[file.Name] {
+				counted[file.Name] = true
+				stats.Chunks += len(manifest.Chunks)
+				stats.Bytes += manifest.Size
 			}
-			defer res.Body.Close()
+			mu.Unlock()
+			manifests = append(manifests, manifest)
+		}
 
-			if res.StatusCode == http.StatusOK {
-				ch <- end
-				break
-			}
+		var sent UploadStats
+		err := c.uploadMissingChunks(ctx, chunks, &sent)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		stats.UploadedChunks += sent.UploadedChunks
+		stats.UploadedBytes += sent.UploadedBytes
+		for hash := range chunks {
+			uploaded[hash] = true
+		}
+		mu.Unlock()
 
-			elapsed = time.Since(start)
+		return json.Marshal(manifests)
+	})
+	return stats, err
+}
 
-			if elapsed >= retryTimeout {
-				return fmt.Errorf("server responded with non-OK status: %d (Timeout: %s exceeded)", res.StatusCode, retryTimeout)
-			}
+// uploadMissingChunks asks the server which of chunks it is missing and
+// uploads only those
+func (c *Client) uploadMissingChunks(ctx context.Context, chunks map[string][]byte, stats *UploadStats) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, 0, len(chunks))
+	for hash := range chunks {
+		hashes = append(hashes, []byte(hash))
+	}
+	jsonData, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
 
-			currentBatchSize = max(currentBatchSize/2, 1)
-			attemptCount += 1
-			fmt.Println(fmt.Errorf("error code: %d retrying will smaller batch size %d (attempt: %d)", res.StatusCode, currentBatchSize, attemptCount))
-			time.Sleep(retryInterval)
+	res, err := c.postIdempotent(ctx, "/files/missing-chunks", jsonData)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var missing [][]byte
+	err = json.NewDecoder(res.Body).Decode(&missing)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	upload := make([]chunkUpload, 0, len(missing))
+	for _, hash := range missing {
+		data, ok := chunks[string(hash)]
+		if !ok {
+			return fmt.Errorf("server asked for unknown chunk %x", hash)
 		}
+		upload = append(upload, chunkUpload{Hash: hash, Data: data})
+		stats.UploadedChunks++
+		stats.UploadedBytes += int64(len(data))
 	}
+	jsonData, err = json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	// Chunks are stored by their hash, so sending one twice is harmless
+	res, err = c.postIdempotent(ctx, "/files/upload-chunks", jsonData)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
 	return nil
 }
 
-func GetProof(url string, id string) (merkletree.MerkleProof, error) {
-	requestUrl := fmt.Sprintf("%s/files/get-proof/%s", url, id)
-	res, err := http.Get(requestUrl)
+// Proofs are requested in their binary encoding, falling back to JSON for
+// servers that don't support it.
+const (
+	binaryProofContentType = "application/octet-stream"
+	jsonContentType        = "application/json"
+)
+
+func (c *Client) GetProof(ctx context.Context, id string) (merkletree.MerkleProof, error) {
+	path := fmt.Sprintf("/files/get-proof/%s", id)
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
 	if err != nil {
-		return nil, err
+		return merkletree.MerkleProof{}, err
+	}
+	req.Header.Set("Accept", fmt.Sprintf("%s, %s;q=0.9", binaryProofContentType, jsonContentType))
+
+	res, err := c.do(req)
+	if err != nil {
+		return merkletree.MerkleProof{}, err
 	}
 	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return merkletree.MerkleProof{}, err
 	}
 
 	var proof merkletree.MerkleProof
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType == binaryProofContentType {
+		err = proof.UnmarshalBinary(body)
+	} else {
+		err = json.Unmarshal(body, &proof)
+	}
+	if err != nil {
+		return merkletree.MerkleProof{}, err
+	}
+
+	return proof, nil
+}
+
+// GetMultiProof fetches one proof covering every id. The server returns the
+// leaf indices in the same order as ids.
+func (c *Client) GetMultiProof(ctx context.Context, ids []string) (merkletree.MultiProof, error) {
+	jsonData, err := json.Marshal(ids)
+	if err != nil {
+		return merkletree.MultiProof{}, err
+	}
+
+	res, err := c.postIdempotent(ctx, "/files/get-multiproof", jsonData)
+	if err != nil {
+		return merkletree.MultiProof{}, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return merkletree.MultiProof{}, err
+	}
+
+	var proof merkletree.MultiProof
 	err = json.Unmarshal(body, &proof)
 	if err != nil {
-		return nil, err
+		return merkletree.MultiProof{}, err
+	}
+
+	if len(proof.LeafIndices) != len(ids) {
+		return merkletree.MultiProof{}, fmt.Errorf("expected %d leaf indices in multiproof, got %d", len(ids), len(proof.LeafIndices))
 	}
 
 	return proof, nil
 }
 
-func GetFile(url string, id string) (string, []byte, error) {
-	requestUrl := fmt.Sprintf("%s/files/download/%s", url, id)
-	res, err := http.Get(requestUrl)
+type consistencyResponse struct {
+	Root  []byte
+	Proof merkletree.ConsistencyProof
+}
+
+// GetConsistencyProof fetches the server's current root along with a proof
+// that its tree extends the first oldSize leaves.
+func (c *Client) GetConsistencyProof(ctx context.Context, oldSize int) (merkletree.ConsistencyProof, []byte, error) {
+	path := fmt.Sprintf("/files/get-consistency-proof?old-size=%d", oldSize)
+	res, err := c.get(ctx, path)
 	if err != nil {
-		return "", nil, err
+		return merkletree.ConsistencyProof{}, nil, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusNotFound {
-		return "", nil, errors.New("No file found for id: " + id)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return merkletree.ConsistencyProof{}, nil, err
+	}
+
+	var response consistencyResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return merkletree.ConsistencyProof{}, nil, err
+	}
+
+	return response.Proof, response.Root, nil
+}
+
+type sparseProofResponse struct {
+	Root  []byte
+	Proof merkletree.SparseProof
+}
+
+// GetSparseProof fetches the root of the server's sparse Merkle tree of file
+// names along with a proof for fileName, which proves the file is absent
+// when the server no longer has it.
+func (c *Client) GetSparseProof(ctx context.Context, fileName string) (merkletree.SparseProof, []byte, error) {
+	path := fmt.Sprintf("/files/get-sparse-proof/%s", neturl.PathEscape(fileName))
+	res, err := c.get(ctx, path)
+	if err != nil {
+		return merkletree.SparseProof{}, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return merkletree.SparseProof{}, nil, err
+	}
+
+	var response sparseProofResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return merkletree.SparseProof{}, nil, err
+	}
+
+	return response.Proof, response.Root, nil
+}
+
+// FileHash is the hash the server has for the file with Name
+type FileHash struct {
+	Name string
+	Hash []byte
+}
+
+// GetFileHashes fetches the name and hash of every file the server has, as
+// it hashes them now, in file id order
+func (c *Client) GetFileHashes(ctx context.Context) ([]FileHash, error) {
+	res, err := c.get(ctx, "/files/hashes")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []FileHash
+	err = json.Unmarshal(body, &hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// GetFile downloads a file along with the name and mode the server has for
+// it. Mode is 0 when the server doesn't send one.
+func (c *Client) GetFile(ctx context.Context, id string) (fileutil.File, error) {
+	path := fmt.Sprintf("/files/download/%s", id)
+	res, err := c.get(ctx, path)
+	if err != nil {
+		return fileutil.File{}, err
 	}
+	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fileutil.File{}, err
+	}
+
+	fileName, err := fileNameFromHeader(res.Header)
+	if err != nil {
+		return fileutil.File{}, err
+	}
+
+	var mode uint64
+	if header := res.Header.Get("X-File-Mode"); header != "" {
+		mode, err = strconv.ParseUint(header, 8, 32)
+		if err != nil {
+			return fileutil.File{}, fmt.Errorf("invalid file mode from server: %s", header)
+		}
+	}
+
+	return fileutil.File{Name: fileName, Data: body, Mode: fs.FileMode(mode).Perm()}, nil
+}
+
+// GetChunk downloads one chunk of a file split into the tree's chunk size
+func (c *Client) GetChunk(ctx context.Context, id string, index int) (string, []byte, error) {
+	path := fmt.Sprintf("/files/download/%s/chunks/%d", id, index)
+	res, err := c.get(ctx, path)
 	if err != nil {
 		return "", nil, err
 	}
+	defer res.Body.Close()
 
-	if len(res.Header["Content-Disposition"]) == 0 {
-		return "", nil, errors.New("filename missing from header")
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", nil, err
 	}
 
-	_, params, err := mime.ParseMediaType(res.Header["Content-Disposition"][0])
+	fileName, err := fileNameFromHeader(res.Header)
 	if err != nil {
 		return "", nil, err
 	}
-	fileName := params["filename"]
 
 	return fileName, body, nil
 }
 
-func DeleteAllFiles(url string) error {
-	requestUrl := url + "/files/delete-all"
-	res, err := http.Post(requestUrl, "application/json", nil)
+func (c *Client) GetChunkProof(ctx context.Context, id string, index int) (merkletree.ChunkProof, error) {
+	path := fmt.Sprintf("/files/get-chunk-proof/%s/%d", id, index)
+	res, err := c.get(ctx, path)
 	if err != nil {
-		return err
+		return merkletree.ChunkProof{}, err
 	}
 	defer res.Body.Close()
 
-	return nil
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return merkletree.ChunkProof{}, err
+	}
+
+	var proof merkletree.ChunkProof
+	err = json.Unmarshal(body, &proof)
+	if err != nil {
+		return merkletree.ChunkProof{}, err
+	}
+
+	return proof, nil
 }
 
-func CorruptFile(url string, id string, file []byte) error {
-	requestUrl := fmt.Sprintf("%s/files/corrupt-file/%s", url, id)
-	jsonData, err := json.Marshal(file)
+func fileNameFromHeader(header http.Header) (string, error) {
+	if len(header["Content-Disposition"]) == 0 {
+		return "", errors.New("filename missing from header")
+	}
+
+	_, params, err := mime.ParseMediaType(header["Content-Disposition"][0])
 	if err != nil {
-		return err
+		return "", err
 	}
+	return params["filename"], nil
+}
 
-	res, err := http.Post(requestUrl, "application/json", bytes.NewBuffer(jsonData))
+func (c *Client) DeleteAllFiles(ctx context.Context) error {
+	res, err := c.post(ctx, "/files/delete-all", nil)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
+	res.Body.Close()
+
+	return nil
+}
 
-	if res.StatusCode == http.StatusNotFound {
-		return errors.New("No file found for id: " + id)
+func (c *Client) CorruptFile(ctx context.Context, id string, file []byte) error {
+	path := fmt.Sprintf("/files/corrupt-file/%s", id)
+	jsonData, err := json.Marshal(file)
+	if err != nil {
+		return err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server responded with non-OK status: %d", res.StatusCode)
+	res, err := c.post(ctx, path, jsonData)
+	if err != nil {
+		return err
 	}
+	defer res.Body.Close()
 
 	return nil
 }
 
-func Ping(url string) error {
-	_, err := http.Get(url)
+func (c *Client) Ping(ctx context.Context) error {
+	res, err := c.get(ctx, "/")
 	if err != nil {
 		return err
 	}
+	res.Body.Close()
 
 	return nil
 }
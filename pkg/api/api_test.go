@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
+)
+
+func TestClientDefaultHeaders(t *testing.T) {
+	var gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/", WithHeader("Authorization", "Bearer token"))
+	err := client.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if gotPath != "/" {
+		t.Errorf("got path %s, want /", gotPath)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("got authorization header %q, want %q", gotAuth, "Bearer token")
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/files/download/3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="3.txt"`)
+		w.Header().Set("X-File-Mode", "644")
+		io.WriteString(w, "Hello 3")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	file, err := client.GetFile(context.Background(), "3")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	want := fileutil.File{Name: "3.txt", Data: []byte("Hello 3"), Mode: 0644}
+	if !reflect.DeepEqual(file, want) {
+		t.Errorf("got %v, want %v", file, want)
+	}
+
+	if _, err := client.GetFile(context.Background(), "4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestGetProof(t *testing.T) {
+	var leafHashes [][]byte
+	for _, data := range []string{"a", "b", "c"} {
+		hash := sha256.Sum256([]byte(data))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := merkletree.BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	proof, err := tree.CreateProof(2)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for _, binary := range []bool{true, false} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if binary {
+				data, _ := proof.MarshalBinary()
+				w.Header().Set("Content-Type", binaryProofContentType)
+				w.Write(data)
+				return
+			}
+			w.Header().Set("Content-Type", jsonContentType)
+			json.NewEncoder(w).Encode(proof)
+		}))
+
+		got, err := NewClient(server.URL).GetProof(context.Background(), "2")
+		server.Close()
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if valid, _ := tree.VerifyProof(leafHashes[2], got); !valid {
+			t.Errorf("expected proof to be valid when binary is %v", binary)
+		}
+	}
+}
+
+func TestUploadFiles(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	var uploaded []fileutil.File
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/files/upload-batch/") {
+			http.NotFound(w, r)
+			return
+		}
+		var batch []fileutil.File
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		queries = append(queries, r.URL.RawQuery)
+		uploaded = append(uploaded, batch...)
+	}))
+	defer server.Close()
+
+	files := fileutil.Files{
+		{Name: "0.txt", Data: []byte("Hello 0")},
+		{Name: "1.txt", Data: []byte("Hello 1")},
+	}
+	ch := make(chan int, len(files))
+	err := NewClient(server.URL).UploadFiles(context.Background(), files, ch)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(uploaded, []fileutil.File(files)) {
+		t.Errorf("got %v, want %v", uploaded, files)
+	}
+	if len(queries) != 1 || queries[0] != "batch-complete=true" {
+		t.Errorf("expected one batch marked complete, got queries %v", queries)
+	}
+	if got := <-ch; got != len(files) {
+		t.Errorf("got progress %d, want %d", got, len(files))
+	}
+}
+
+func TestCorruptFileAndDeleteAllFiles(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/files/corrupt-file/9" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.CorruptFile(context.Background(), "1", []byte("corrupt")); err != nil {
+		t.Errorf("returned unexpected error: %v", err)
+	}
+	if err := client.CorruptFile(context.Background(), "9", []byte("corrupt")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrNotFound)
+	}
+	if err := client.DeleteAllFiles(context.Background()); err != nil {
+		t.Errorf("returned unexpected error: %v", err)
+	}
+
+	want := []string{"POST /files/corrupt-file/1", "POST /files/corrupt-file/9", "POST /files/delete-all"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got requests %v, want %v", requests, want)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewClient(server.URL).GetFile(ctx, "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	client := NewClient(server.URL, WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}))
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("expected an error when the client times out")
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds each request made by a Client that wasn't given its
+// own http.Client, so a hung server can't block forever
+const DefaultTimeout = time.Minute
+
+// Client talks to the file server at BaseURL. Every request is sent with
+// HTTPClient, carries Header, is retried as Retry allows for its method and
+// is cancelled along with its context.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Header     http.Header
+	Retry      RetryPolicy
+}
+
+type ClientOption func(*Client)
+
+// WithHTTPClient sends requests with httpClient, for a different transport
+// or timeout than the default
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.HTTPClient = httpClient
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy, a zero RetryPolicy turns
+// retries off
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.Retry = policy
+	}
+}
+
+// WithHeader adds a header to every request
+func WithHeader(key string, value string) ClientOption {
+	return func(c *Client) {
+		c.Header.Add(key, value)
+	}
+}
+
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	client := &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+		Header:     make(http.Header),
+		Retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
+}
+
+// newRequest makes a request for path on the server with the default headers
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", jsonContentType)
+	}
+	return req, nil
+}
+
+// do sends req, retrying it as the client's policy allows for its method. A
+// non-2xx response is returned as an *Error.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	return c.doWith(req, c.Retry.forMethod(req.Method))
+}
+
+// doWith sends req, retrying it as policy allows
+func (c *Client) doWith(req *http.Request, policy RetryPolicy) (*http.Response, error) {
+	var res *http.Response
+	err := policy.Do(req.Context(), func(attempt int) error {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			req.Body = body
+		}
+
+		var err error
+		res, err = c.send(req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// send makes a single attempt at req
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkResponse(res)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// post sends jsonData, which may be nil for an empty body
+func (c *Client) post(ctx context.Context, path string, jsonData []byte) (*http.Response, error) {
+	req, err := c.newPost(ctx, path, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// postIdempotent is post for a request that is safe to send twice, such as a
+// query, so it is retried like a GET
+func (c *Client) postIdempotent(ctx context.Context, path string, jsonData []byte) (*http.Response, error) {
+	req, err := c.newPost(ctx, path, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	return c.doWith(req, c.Retry)
+}
+
+func (c *Client) newPost(ctx context.Context, path string, jsonData []byte) (*http.Request, error) {
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewReader(jsonData)
+	}
+	return c.newRequest(ctx, http.MethodPost, path, body)
+}
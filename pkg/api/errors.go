@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors an *Error matches with errors.Is, depending on its status code
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrServerBusy   = errors.New("server busy")
+)
+
+// maxErrorBody caps how much of an error response is read for its message
+const maxErrorBody = 4096
+
+// Error is a response from the server with a non-2xx status
+type Error struct {
+	StatusCode int
+	Method     string
+	Endpoint   string
+	// RequestID is the server's X-Request-Id, if it sent one
+	RequestID string
+	// Message is the server's error message, decoded from a JSON body when
+	// it has an "error" or "message" field
+	Message string
+	// RetryAfter is how long the server asked the client to wait
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("%s %s: server responded with status %d", e.Method, e.Endpoint, e.StatusCode)
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		message += fmt.Sprintf(" (request id: %s)", e.RequestID)
+	}
+	return message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrServerBusy:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// checkResponse returns an *Error for a non-2xx response, reading the server's
+// message from its body
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	apiErr := &Error{
+		StatusCode: res.StatusCode,
+		RequestID:  res.Header.Get("X-Request-Id"),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+	if res.Request != nil {
+		apiErr.Method = res.Request.Method
+		apiErr.Endpoint = res.Request.URL.Path
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	var decoded struct {
+		Error   string
+		Message string
+	}
+	if json.Unmarshal(body, &decoded) == nil && (decoded.Error != "" || decoded.Message != "") {
+		apiErr.Message = decoded.Error
+		if apiErr.Message == "" {
+			apiErr.Message = decoded.Message
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+
+	return apiErr
+}
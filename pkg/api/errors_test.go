@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		message     string
+		sentinel    error
+	}{
+		{name: "json error", status: http.StatusNotFound, contentType: jsonContentType, body: `{"error": "file 7 not found"}`, message: "file 7 not found", sentinel: ErrNotFound},
+		{name: "json message", status: http.StatusUnauthorized, contentType: jsonContentType, body: `{"message": "missing token"}`, message: "missing token", sentinel: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, body: "forbidden\n", message: "forbidden", sentinel: ErrUnauthorized},
+		{name: "too many requests", status: http.StatusTooManyRequests, body: "slow down", message: "slow down", sentinel: ErrServerBusy},
+		{name: "unavailable", status: http.StatusServiceUnavailable, sentinel: ErrServerBusy},
+		{name: "internal", status: http.StatusInternalServerError, body: `{"other": 1}`, message: `{"other": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "req-1")
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			_, err := NewClient(server.URL, WithRetryPolicy(RetryPolicy{})).GetProof(context.Background(), "7")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want an *Error", err)
+			}
+			want := Error{
+				StatusCode: tt.status,
+				Method:     http.MethodGet,
+				Endpoint:   "/files/get-proof/7",
+				RequestID:  "req-1",
+				Message:    tt.message,
+			}
+			if *apiErr != want {
+				t.Errorf("got %+v, want %+v", *apiErr, want)
+			}
+
+			for _, sentinel := range []error{ErrNotFound, ErrUnauthorized, ErrServerBusy} {
+				if got, want := errors.Is(err, sentinel), sentinel == tt.sentinel; got != want {
+					t.Errorf("errors.Is(err, %v) = %v, want %v", sentinel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorStatusChecked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithRetryPolicy(RetryPolicy{}))
+	if err := client.Ping(context.Background()); !errors.Is(err, ErrServerBusy) {
+		t.Errorf("Ping: got error %v, want %v", err, ErrServerBusy)
+	}
+	if err := client.DeleteAllFiles(context.Background()); !errors.Is(err, ErrServerBusy) {
+		t.Errorf("DeleteAllFiles: got error %v, want %v", err, ErrServerBusy)
+	}
+	if _, err := client.GetFile(context.Background(), "1"); !errors.Is(err, ErrServerBusy) {
+		t.Errorf("GetFile: got error %v, want %v", err, ErrServerBusy)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/chunker"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+)
+
+// UploadJournal records which batches of an upload the server took, so an
+// upload that was cut short can carry on under the same batch id after a
+// restart and only send the files it is missing. Batches taken out of order
+// leave gaps for the batches that were still in flight.
+type UploadJournal struct {
+	BatchID  string
+	Endpoint string
+	// Chunker is the chunking used by a deduplicated upload
+	Chunker   *chunker.Config `json:",omitempty"`
+	FileCount int
+	// Batches are the batches the server has taken, ordered by Start
+	Batches []JournalBatch
+
+	path string
+}
+
+// JournalBatch is a batch of the files from Start up to End, with a hash of
+// their names, modes and contents so a resumed upload can tell if they
+// changed
+type JournalBatch struct {
+	Start int
+	End   int
+	Hash  []byte
+}
+
+func LoadUploadJournal(path string) (*UploadJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal UploadJournal
+	err = json.Unmarshal(data, &journal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload journal: %w", err)
+	}
+	if journal.BatchID == "" || journal.Endpoint == "" {
+		return nil, errors.New("invalid upload journal: missing batch id or endpoint")
+	}
+
+	end := 0
+	for _, batch := range journal.Batches {
+		if batch.Start < end || batch.End <= batch.Start || batch.End > journal.FileCount {
+			return nil, fmt.Errorf("invalid upload journal: batch from %d to %d is out of order", batch.Start, batch.End)
+		}
+		end = batch.End
+	}
+
+	journal.path = path
+	return &journal, nil
+}
+
+// FileRange is the files from Start up to End
+type FileRange struct {
+	Start int
+	End   int
+}
+
+// Offset is the first file the server hasn't taken
+func (j *UploadJournal) Offset() int {
+	offset := 0
+	for _, batch := range j.Batches {
+		if batch.Start != offset {
+			break
+		}
+		offset = batch.End
+	}
+	return offset
+}
+
+// Uploaded is the number of files the server has taken
+func (j *UploadJournal) Uploaded() int {
+	uploaded := 0
+	for _, batch := range j.Batches {
+		uploaded += batch.End - batch.Start
+	}
+	return uploaded
+}
+
+// Missing is the runs of files the server hasn't taken, in order
+func (j *UploadJournal) Missing() []FileRange {
+	var missing []FileRange
+	start := 0
+	for _, batch := range j.Batches {
+		if batch.Start > start {
+			missing = append(missing, FileRange{Start: start, End: batch.Start})
+		}
+		start = batch.End
+	}
+	if start < j.FileCount {
+		missing = append(missing, FileRange{Start: start, End: j.FileCount})
+	}
+	return missing
+}
+
+func (j *UploadJournal) IsComplete() bool {
+	return j.Uploaded() == j.FileCount
+}
+
+// Verify checks files are the same files the journal was written for, so a
+// resumed upload doesn't mix old and new files
+func (j *UploadJournal) Verify(files fileutil.FileSource) error {
+	if files.Len() != j.FileCount {
+		return fmt.Errorf("upload journal is for %d files but there are %d", j.FileCount, files.Len())
+	}
+
+	for _, batch := range j.Batches {
+		read, err := files.Read(batch.Start, batch.End)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hashBatch(read), batch.Hash) {
+			return fmt.Errorf("files %d to %d changed since they were uploaded", batch.Start, batch.End)
+		}
+	}
+	return nil
+}
+
+// record adds a batch the server took in its place by start and saves the
+// journal
+func (j *UploadJournal) record(start int, end int, hash []byte) error {
+	i := len(j.Batches)
+	for i > 0 && j.Batches[i-1].Start > start {
+		i--
+	}
+	j.Batches = slices.Insert(j.Batches, i, JournalBatch{Start: start, End: end, Hash: hash})
+	return j.save()
+}
+
+// save writes the journal to a temporary file first, so a crash can't leave
+// it half written. A journal without a path isn't saved.
+func (j *UploadJournal) save() error {
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), j.path)
+}
+
+// hashBatch hashes each file's name, mode and contents, with lengths so
+// files can't run into each other
+func hashBatch(files []fileutil.File) []byte {
+	h := sha256.New()
+	for _, file := range files {
+		h.Write(binary.AppendUvarint(nil, uint64(len(file.Name))))
+		h.Write([]byte(file.Name))
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(file.Mode)))
+		h.Write(binary.AppendUvarint(nil, uint64(len(file.Data))))
+		h.Write(file.Data)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+)
+
+func TestResumeUpload(t *testing.T) {
+	files := fileutil.Files{
+		{Name: "0.txt", Data: []byte("Hello 0")},
+		{Name: "1.txt", Data: []byte("Hello 1")},
+		{Name: "2.txt", Data: []byte("Hello 2")},
+		{Name: "3.txt", Data: []byte("Hello 3")},
+		{Name: "4.txt", Data: []byte("Hello 4")},
+	}
+
+	// The server takes two files at a time, and stops taking anything after
+	// the first two batches until it is restarted
+	failing := true
+	var paths []string
+	var uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []fileutil.File
+		json.NewDecoder(r.Body).Decode(&batch)
+		if len(batch) > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if failing && len(uploaded) == 4 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		paths = append(paths, r.URL.Path)
+		for _, file := range batch {
+			uploaded = append(uploaded, file.Name)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+	client := NewClient(server.URL, WithRetryPolicy(testRetryPolicy))
+	ch := make(chan int, len(files)+1)
+	err := client.UploadFiles(context.Background(), files, ch, WithJournal(path))
+	if err == nil {
+		t.Fatal("expected the first upload to fail")
+	}
+
+	journal, err := LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if journal.Offset() != 4 || journal.FileCount != len(files) || journal.IsComplete() {
+		t.Errorf("got journal at %d of %d files, want 4 of %d", journal.Offset(), journal.FileCount, len(files))
+	}
+
+	failing = false
+	_, err = NewClient(server.URL, WithRetryPolicy(testRetryPolicy)).ResumeUpload(context.Background(), files, path, ch)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if got := strings.Join(uploaded, ","); got != "0.txt,1.txt,2.txt,3.txt,4.txt" {
+		t.Errorf("got uploaded files %s", got)
+	}
+	for _, batchPath := range paths {
+		if batchPath != paths[0] {
+			t.Errorf("got batch path %s, want every batch under %s", batchPath, paths[0])
+		}
+	}
+
+	journal, err = LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !journal.IsComplete() {
+		t.Error("expected the journal to be complete")
+	}
+	if _, err := client.ResumeUpload(context.Background(), files, path, ch); err == nil {
+		t.Error("expected an error for resuming a complete upload")
+	}
+}
+
+func TestResumeUploadWithGaps(t *testing.T) {
+	files := testFiles(8)
+
+	var uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []fileutil.File
+		json.NewDecoder(r.Body).Decode(&batch)
+		for _, file := range batch {
+			uploaded = append(uploaded, file.Name)
+		}
+	}))
+	defer server.Close()
+
+	// The server took two batches out of order before the upload stopped
+	path := filepath.Join(t.TempDir(), "journal.json")
+	journal, err := newUploadJournal(uploadEndpoint, len(files), nil, path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	journal.record(5, 6, hashBatch(files[5:6]))
+	journal.record(1, 3, hashBatch(files[1:3]))
+
+	journal, err = LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if journal.Offset() != 0 || journal.Uploaded() != 3 || journal.IsComplete() {
+		t.Errorf("got journal offset %d with %d files uploaded, want 0 with 3", journal.Offset(), journal.Uploaded())
+	}
+	want := []FileRange{{0, 1}, {3, 5}, {6, 8}}
+	if got := journal.Missing(); !slices.Equal(got, want) {
+		t.Errorf("got missing files %v, want %v", got, want)
+	}
+
+	ch := make(chan int, len(files)+1)
+	client := NewClient(server.URL, WithRetryPolicy(testRetryPolicy))
+	_, err = client.ResumeUpload(context.Background(), files, path, ch)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if got := strings.Join(uploaded, ","); got != "a.txt,d.txt,e.txt,g.txt,h.txt" {
+		t.Errorf("got uploaded files %s", got)
+	}
+	close(ch)
+	last := 0
+	for progress := range ch {
+		last = progress
+	}
+	if last != len(files) {
+		t.Errorf("got progress %d, want %d", last, len(files))
+	}
+
+	journal, err = LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !journal.IsComplete() || journal.Offset() != len(files) {
+		t.Error("expected the journal to be complete")
+	}
+}
+
+func TestUploadJournalVerify(t *testing.T) {
+	files := fileutil.Files{
+		{Name: "0.txt", Data: []byte("Hello 0")},
+		{Name: "1.txt", Data: []byte("Hello 1")},
+		{Name: "2.txt", Data: []byte("Hello 2")},
+	}
+	journal := &UploadJournal{BatchID: "id", Endpoint: uploadEndpoint, FileCount: len(files)}
+	journal.record(0, 2, hashBatch(files[:2]))
+
+	if err := journal.Verify(files); err != nil {
+		t.Errorf("returned unexpected error: %v", err)
+	}
+
+	changed := append(fileutil.Files{}, files...)
+	changed[1] = fileutil.File{Name: "1.txt", Data: []byte("Hello 1"), Mode: 0600}
+	if err := journal.Verify(changed); err == nil {
+		t.Error("expected an error for a file with a different mode")
+	}
+	changed[1] = fileutil.File{Name: "1.txt", Data: []byte("Hello one")}
+	if err := journal.Verify(changed); err == nil {
+		t.Error("expected an error for a changed file")
+	}
+	// Only files in batches the server took are checked
+	changed[1] = files[1]
+	changed[2] = fileutil.File{Name: "2.txt", Data: []byte("Hello two")}
+	if err := journal.Verify(changed); err != nil {
+		t.Errorf("returned unexpected error: %v", err)
+	}
+	if err := journal.Verify(files[:2]); err == nil {
+		t.Error("expected an error for a different number of files")
+	}
+}
+
+func TestLoadUploadJournalInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadUploadJournal(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want a not exist error", err)
+	}
+
+	tests := map[string]string{
+		"not json":     `{`,
+		"no batch id":  `{"Endpoint": "/files/upload-batch", "FileCount": 2}`,
+		"out of order": `{"BatchID": "id", "Endpoint": "/files/upload-batch", "FileCount": 4, "Batches": [{"Start": 3, "End": 4}, {"Start": 0, "End": 2}]}`,
+		"overlapping":  `{"BatchID": "id", "Endpoint": "/files/upload-batch", "FileCount": 4, "Batches": [{"Start": 0, "End": 2}, {"Start": 1, "End": 3}]}`,
+		"too many":     `{"BatchID": "id", "Endpoint": "/files/upload-batch", "FileCount": 1, "Batches": [{"Start": 0, "End": 2}]}`,
+	}
+	for name, data := range tests {
+		path := filepath.Join(dir, "journal.json")
+		os.WriteFile(path, []byte(data), 0644)
+		if _, err := LoadUploadJournal(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
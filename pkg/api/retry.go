@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides which failed requests are tried again and how long to
+// wait before each attempt. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts int
+	// BaseDelay is doubled after every attempt, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest Retry-After the policy waits for. A
+	// request the server asks to wait longer for isn't retried. Zero means
+	// MaxDelay.
+	MaxRetryAfter time.Duration
+	// Jitter is the fraction of each delay that is randomly taken off, so
+	// clients that failed together don't all retry together
+	Jitter float64
+	// RetryableStatuses are the response statuses worth trying again
+	RetryableStatuses []int
+	// RetryTransportErrors retries requests that failed before the server
+	// responded, such as a refused or dropped connection
+	RetryTransportErrors bool
+
+	// busyNeedsRetryAfter only retries a busy server that said when to come
+	// back
+	busyNeedsRetryAfter bool
+}
+
+// DefaultRetryPolicy retries transport errors and statuses that can succeed
+// later, giving up after about 10 seconds unless the server asks to wait for
+// up to 30 seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   8,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+	Jitter:        0.5,
+	RetryableStatuses: []int{
+		http.StatusRequestTimeout,
+		http.StatusRequestEntityTooLarge,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+	RetryTransportErrors: true,
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable or has
+// been called MaxAttempts times. fn is given the attempt number, from 1.
+func (p RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !p.Retryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt, err))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Retryable reports whether a request that failed with err is worth trying
+// again. Only errors from the server or the transport are ever retried, and
+// never when the server asks to wait longer than MaxRetryAfter.
+func (p RetryPolicy) Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if p.busyNeedsRetryAfter && errors.Is(apiErr, ErrServerBusy) && apiErr.RetryAfter == 0 {
+			return false
+		}
+		return slices.Contains(p.RetryableStatuses, apiErr.StatusCode) && apiErr.RetryAfter <= p.maxRetryAfter()
+	}
+	var urlErr *url.Error
+	return p.RetryTransportErrors && errors.As(err, &urlErr)
+}
+
+// forMethod is the policy for a request with method. A request that isn't
+// idempotent may have been acted on by a server that failed or didn't
+// respond, so it is only retried when the server was busy and said when to
+// come back.
+func (p RetryPolicy) forMethod(method string) RetryPolicy {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return p
+	}
+
+	p.RetryableStatuses = slices.DeleteFunc(slices.Clone(p.RetryableStatuses), func(status int) bool {
+		return status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable
+	})
+	p.RetryTransportErrors = false
+	p.busyNeedsRetryAfter = true
+	return p
+}
+
+// Delay is how long to wait after the given failed attempt. The server's
+// Retry-After is honoured when it asks for longer, up to MaxRetryAfter.
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = min(apiErr.RetryAfter, p.maxRetryAfter())
+	}
+	return delay
+}
+
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter == 0 {
+		return p.MaxDelay
+	}
+	return p.MaxRetryAfter
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or a date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:          12,
+	BaseDelay:            time.Millisecond,
+	MaxDelay:             4 * time.Millisecond,
+	RetryableStatuses:    DefaultRetryPolicy.RetryableStatuses,
+	RetryTransportErrors: true,
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, delay := range want {
+		if got := policy.Delay(i+1, nil); got != delay*time.Millisecond {
+			t.Errorf("attempt %d: got delay %s, want %s", i+1, got, delay*time.Millisecond)
+		}
+	}
+
+	busy := &Error{StatusCode: http.StatusServiceUnavailable, RetryAfter: 3 * time.Second}
+	if got := policy.Delay(1, busy); got != time.Second {
+		t.Errorf("got delay %s, want the server's Retry-After capped at 1s", got)
+	}
+	policy.MaxRetryAfter = 5 * time.Second
+	if got := policy.Delay(1, busy); got != 3*time.Second {
+		t.Errorf("got delay %s, want the server's Retry-After of 3s", got)
+	}
+	policy.MaxRetryAfter = 0
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := policy.Delay(2, nil)
+		if got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("got delay %s, want between 100ms and 200ms", got)
+		}
+	}
+}
+
+func TestRetryPolicyRetryAfterTooLong(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:       3,
+		MaxDelay:          time.Millisecond,
+		MaxRetryAfter:     time.Second,
+		RetryableStatuses: []int{http.StatusServiceUnavailable},
+	}
+	if !policy.Retryable(&Error{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Second}) {
+		t.Error("expected a Retry-After within MaxRetryAfter to be retried")
+	}
+
+	attempts := 0
+	start := time.Now()
+	err := policy.Do(context.Background(), func(attempt int) error {
+		attempts++
+		return &Error{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}
+	})
+	if !errors.Is(err, ErrServerBusy) || attempts != 1 {
+		t.Errorf("got error %v after %d attempts, want to give up after 1", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s, want no wait", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got != 2*time.Second {
+		t.Errorf("got %s, want 2s", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("got %s, want about an hour", got)
+	}
+	for _, header := range []string{"", "-1", "soon"} {
+		if got := parseRetryAfter(header); got != 0 {
+			t.Errorf("%q: got %s, want 0", header, got)
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  error
+		requests int
+	}{
+		{name: "recovers", statuses: []int{503, 500, 200}, requests: 3},
+		{name: "not retryable", statuses: []int{400, 200}, wantErr: &Error{}, requests: 1},
+		{name: "gives up", statuses: []int{429, 429, 429, 429}, wantErr: ErrServerBusy, requests: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(tt.statuses[len(bodies)-1])
+				w.Write([]byte(`{"LeafIndices": [0]}`))
+			}))
+			defer server.Close()
+
+			policy := testRetryPolicy
+			policy.MaxAttempts = 3
+			client := NewClient(server.URL, WithRetryPolicy(policy))
+			_, err := client.GetMultiProof(context.Background(), []string{"1"})
+
+			var apiErr *Error
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("returned unexpected error: %v", err)
+			case tt.wantErr == ErrServerBusy && !errors.Is(err, ErrServerBusy):
+				t.Errorf("got error %v, want %v", err, ErrServerBusy)
+			case tt.wantErr != nil && !errors.As(err, &apiErr):
+				t.Errorf("got error %v, want an *Error", err)
+			}
+			if len(bodies) != tt.requests {
+				t.Errorf("got %d requests, want %d", len(bodies), tt.requests)
+			}
+			for _, body := range bodies {
+				if body != bodies[0] {
+					t.Errorf("got body %s on retry, want %s", body, bodies[0])
+				}
+			}
+		})
+	}
+}
+
+func TestClientRetriesNonIdempotent(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		requests   int
+	}{
+		{name: "server error", status: http.StatusInternalServerError, requests: 1},
+		{name: "busy", status: http.StatusServiceUnavailable, requests: 1},
+		{name: "busy with retry after", status: http.StatusServiceUnavailable, retryAfter: "1", requests: 2},
+		{name: "too many requests with retry after", status: http.StatusTooManyRequests, retryAfter: "1", requests: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.status)
+				}
+			}))
+			defer server.Close()
+
+			policy := testRetryPolicy
+			policy.MaxRetryAfter = time.Second
+			client := NewClient(server.URL, WithRetryPolicy(policy))
+			err := client.CorruptFile(context.Background(), "1", []byte("corrupt"))
+			if tt.requests > 1 && err != nil {
+				t.Errorf("returned unexpected error: %v", err)
+			}
+			if tt.requests == 1 && err == nil {
+				t.Error("expected an error")
+			}
+			if requests != tt.requests {
+				t.Errorf("got %d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestClientRetriesTransportErrors(t *testing.T) {
+	for _, retry := range []bool{true, false} {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			}
+		}))
+
+		policy := testRetryPolicy
+		policy.RetryTransportErrors = retry
+		err := NewClient(server.URL, WithRetryPolicy(policy)).Ping(context.Background())
+		server.Close()
+		if retry && (err != nil || requests != 2) {
+			t.Errorf("got error %v after %d requests, want success after 2", err, requests)
+		}
+		if !retry && err == nil {
+			t.Error("expected an error when transport errors aren't retried")
+		}
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	err := NewClient(server.URL, WithRetryPolicy(testRetryPolicy)).DeleteAllFiles(context.Background())
+	server.Close()
+	if err == nil || requests != 1 {
+		t.Errorf("got error %v after %d requests, want an error after 1", err, requests)
+	}
+}
+
+func TestUploadFilesRetries(t *testing.T) {
+	files := fileutil.Files{
+		{Name: "0.txt", Data: []byte("Hello 0")},
+		{Name: "1.txt", Data: []byte("Hello 1")},
+		{Name: "2.txt", Data: []byte("Hello 2")},
+		{Name: "3.txt", Data: []byte("Hello 3")},
+		{Name: "4.txt", Data: []byte("Hello 4")},
+	}
+
+	tests := []struct {
+		name string
+		// reject returns the status for a batch the server won't take
+		reject    func(request int, batch []fileutil.File) int
+		wantSizes []int
+	}{
+		{
+			name: "too large",
+			reject: func(request int, batch []fileutil.File) int {
+				if len(batch) > 3 {
+					return http.StatusRequestEntityTooLarge
+				}
+				return 0
+			},
+			wantSizes: []int{2, 2, 1},
+		},
+		{
+			name: "busy",
+			reject: func(request int, batch []fileutil.File) int {
+				if request < 2 {
+					return http.StatusServiceUnavailable
+				}
+				return 0
+			},
+			wantSizes: []int{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			var sizes []int
+			var completed []bool
+			var uploaded []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				var batch []fileutil.File
+				json.NewDecoder(r.Body).Decode(&batch)
+				if status := tt.reject(requests, batch); status != 0 {
+					if status == http.StatusServiceUnavailable {
+						w.Header().Set("Retry-After", "1")
+					}
+					w.WriteHeader(status)
+					return
+				}
+
+				sizes = append(sizes, len(batch))
+				completed = append(completed, r.URL.Query().Get("batch-complete") == "true")
+				for _, file := range batch {
+					uploaded = append(uploaded, file.Name)
+				}
+			}))
+			defer server.Close()
+
+			ch := make(chan int, len(files))
+			policy := testRetryPolicy
+			policy.MaxRetryAfter = time.Second
+			client := NewClient(server.URL, WithRetryPolicy(policy))
+			err := client.UploadFiles(context.Background(), files, ch)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			if got := strings.Join(uploaded, ","); got != "0.txt,1.txt,2.txt,3.txt,4.txt" {
+				t.Errorf("got uploaded files %s", got)
+			}
+			if len(sizes) != len(tt.wantSizes) {
+				t.Fatalf("got batch sizes %v, want %v", sizes, tt.wantSizes)
+			}
+			for i := range sizes {
+				if sizes[i] != tt.wantSizes[i] {
+					t.Errorf("got batch sizes %v, want %v", sizes, tt.wantSizes)
+				}
+				if completed[i] != (i == len(sizes)-1) {
+					t.Errorf("batch %d: got batch-complete %v", i, completed[i])
+				}
+			}
+
+			close(ch)
+			last := 0
+			for progress := range ch {
+				last = progress
+			}
+			if last != len(files) {
+				t.Errorf("got progress %d, want %d", last, len(files))
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+)
+
+// defaultBatchSize is how many files each upload worker starts sending per
+// batch
+const defaultBatchSize = 4000
+
+type UploadOption func(*uploadConfig)
+
+type uploadConfig struct {
+	journalPath string
+	parallelism int
+}
+
+// WithJournal saves the upload's progress to path after every batch the
+// server takes, for ResumeUpload to pick up
+func WithJournal(path string) UploadOption {
+	return func(c *uploadConfig) {
+		c.journalPath = path
+	}
+}
+
+// WithParallelism sends up to n batches at once. The default is 1.
+func WithParallelism(n int) UploadOption {
+	return func(c *uploadConfig) {
+		c.parallelism = n
+	}
+}
+
+func newUploadConfig(opts []UploadOption) uploadConfig {
+	config := uploadConfig{parallelism: 1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	config.parallelism = max(config.parallelism, 1)
+	return config
+}
+
+// batchUpload is the state the workers of one upload share
+type batchUpload struct {
+	client *Client
+	// retry is the client's policy for a batch, which isn't safe to send
+	// again unless the server refused it
+	retry   RetryPolicy
+	journal *UploadJournal
+	files   fileutil.FileSource
+	ch      chan<- int
+	encode  func(batch []fileutil.File) ([]byte, error)
+	path    string
+
+	mu sync.Mutex
+	// taken is signalled whenever the server takes a batch
+	taken *sync.Cond
+	// unclaimed are the runs of files the server is missing that no worker
+	// has claimed yet, in order
+	unclaimed []FileRange
+	// uploaded counts the files the server has taken, including in earlier
+	// runs of a resumed upload
+	uploaded int
+	// share is each worker's even share of the files, so a small upload
+	// isn't all claimed by one worker
+	share int
+
+	// sendMu keeps progress in order without holding mu while ch is full
+	sendMu sync.Mutex
+	// sent is the progress last sent on ch
+	sent int
+}
+
+// uploadBatches posts the files the journal is missing in batches encoded by
+// encode, with up to parallelism batches in flight. Each worker claims the
+// next run of missing files and sends it, halving its own batch size whenever
+// the server refuses a batch as too large. A batch is only retried when the
+// server refused it, so a batch the server may have acted on isn't sent
+// twice. The batch that completes the upload is only sent once the server
+// has taken every other batch.
+func (c *Client) uploadBatches(ctx context.Context, journal *UploadJournal, files fileutil.FileSource, ch chan<- int, parallelism int, encode func(batch []fileutil.File) ([]byte, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	u := &batchUpload{
+		client:    c,
+		retry:     c.Retry.forMethod(http.MethodPost),
+		journal:   journal,
+		files:     files,
+		ch:        ch,
+		encode:    encode,
+		path:      fmt.Sprintf("%s/%s", journal.Endpoint, journal.BatchID),
+		unclaimed: journal.Missing(),
+		uploaded:  journal.Uploaded(),
+	}
+	remaining := files.Len() - u.uploaded
+	u.share = max((remaining+parallelism-1)/parallelism, 1)
+	u.taken = sync.NewCond(&u.mu)
+	// A batch the server refused as too large wasn't taken, and is sent
+	// again smaller
+	if slices.Contains(c.Retry.RetryableStatuses, http.StatusRequestEntityTooLarge) {
+		u.retry.RetryableStatuses = append(u.retry.RetryableStatuses, http.StatusRequestEntityTooLarge)
+	}
+	u.progress(u.uploaded)
+
+	// Wake the worker holding the last batch if the upload is abandoned
+	stop := context.AfterFunc(ctx, func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		u.taken.Broadcast()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := u.work(ctx)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// work claims runs of files until there are none left. A size the server
+// couldn't take is kept for the rest of the worker's batches.
+func (u *batchUpload) work(ctx context.Context) error {
+	batchSize := min(defaultBatchSize, u.share)
+	for {
+		start, end, ok := u.claim(batchSize)
+		if !ok {
+			return nil
+		}
+		for start < end {
+			sent, err := u.send(ctx, start, end, &batchSize)
+			if err != nil {
+				return err
+			}
+			start = sent
+		}
+	}
+}
+
+// claim takes up to batchSize unclaimed files from the first run of them
+func (u *batchUpload) claim(batchSize int) (int, int, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.unclaimed) == 0 {
+		return 0, 0, false
+	}
+
+	run := &u.unclaimed[0]
+	start := run.Start
+	end := min(start+batchSize, run.End)
+	run.Start = end
+	if run.Start == run.End {
+		u.unclaimed = u.unclaimed[1:]
+	}
+	return start, end, true
+}
+
+// send uploads one batch from start, no further than limit, and returns
+// where it ended
+func (u *batchUpload) send(ctx context.Context, start int, limit int, batchSize *int) (int, error) {
+	var end int
+	var hash []byte
+	err := u.retry.Do(ctx, func(attempt int) error {
+		end = min(start+*batchSize, limit)
+		path := u.path
+		if end == u.files.Len() {
+			err := u.waitForOthers(ctx, start)
+			if err != nil {
+				return err
+			}
+			path = fmt.Sprintf("%s?batch-complete=%t", u.path, true)
+		}
+
+		batch, err := u.files.Read(start, end)
+		if err != nil {
+			return err
+		}
+		hash = hashBatch(batch)
+
+		jsonData, err := u.encode(batch)
+		if err != nil {
+			return err
+		}
+		req, err := u.client.newRequest(ctx, http.MethodPost, path, bytes.NewReader(jsonData))
+		if err != nil {
+			return err
+		}
+		res, err := u.client.send(req)
+		if err != nil {
+			var apiErr *Error
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestEntityTooLarge {
+				*batchSize = max(*batchSize/2, 1)
+			}
+			return err
+		}
+		res.Body.Close()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return end, u.markTaken(start, end, hash)
+}
+
+// waitForOthers blocks until the server has taken every file before start
+func (u *batchUpload) waitForOthers(ctx context.Context, start int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for u.uploaded < start && ctx.Err() == nil {
+		u.taken.Wait()
+	}
+	return ctx.Err()
+}
+
+// markTaken journals a batch the server has taken and reports the number of
+// files taken so far
+func (u *batchUpload) markTaken(start int, end int, hash []byte) error {
+	u.mu.Lock()
+	u.uploaded += end - start
+	uploaded := u.uploaded
+	err := u.journal.record(start, end, hash)
+	u.taken.Broadcast()
+	u.mu.Unlock()
+
+	u.progress(uploaded)
+	if err != nil {
+		return fmt.Errorf("error saving upload journal: %w", err)
+	}
+	return nil
+}
+
+// progress sends the number of files taken, unless a later count was already
+// sent
+func (u *batchUpload) progress(uploaded int) {
+	u.sendMu.Lock()
+	defer u.sendMu.Unlock()
+	if uploaded > u.sent {
+		u.ch <- uploaded
+		u.sent = uploaded
+	}
+}
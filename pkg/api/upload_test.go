@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/chunker"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
+)
+
+func testFiles(n int) fileutil.Files {
+	var files fileutil.Files
+	for i := 0; i < n; i++ {
+		name := string(rune('a'+i)) + ".txt"
+		files = append(files, fileutil.File{Name: name, Data: []byte("Hello " + name)})
+	}
+	return files
+}
+
+func TestUploadFilesParallel(t *testing.T) {
+	files := testFiles(11)
+
+	var mu sync.Mutex
+	inFlight := 0
+	maxInFlight := 0
+	taken := 0
+	var uploaded []string
+	var lastErr string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []fileutil.File
+		json.NewDecoder(r.Body).Decode(&batch)
+		if len(batch) > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		if r.URL.Query().Get("batch-complete") == "true" && taken+len(batch) != len(files) {
+			lastErr = "the completing batch was sent before every other batch was taken"
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		taken += len(batch)
+		for _, file := range batch {
+			uploaded = append(uploaded, file.Name)
+		}
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+	ch := make(chan int, len(files))
+	client := NewClient(server.URL, WithRetryPolicy(testRetryPolicy))
+	err := client.UploadFiles(context.Background(), files, ch, WithParallelism(4), WithJournal(path))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if lastErr != "" {
+		t.Error(lastErr)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("got at most %d batches in flight, want more than 1", maxInFlight)
+	}
+	sort.Strings(uploaded)
+	var want []string
+	for _, file := range files {
+		want = append(want, file.Name)
+	}
+	if strings.Join(uploaded, ",") != strings.Join(want, ",") {
+		t.Errorf("got uploaded files %v, want %v", uploaded, want)
+	}
+
+	close(ch)
+	last := 0
+	for progress := range ch {
+		if progress <= last {
+			t.Errorf("got progress %d after %d", progress, last)
+		}
+		last = progress
+	}
+	if last != len(files) {
+		t.Errorf("got progress %d, want %d", last, len(files))
+	}
+
+	journal, err := LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !journal.IsComplete() {
+		t.Errorf("got journal at %d of %d files, want it complete", journal.Offset(), journal.FileCount)
+	}
+}
+
+func TestUploadFilesParallelError(t *testing.T) {
+	files := testFiles(8)
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []fileutil.File
+		json.NewDecoder(r.Body).Decode(&batch)
+		if len(batch) > 1 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if batch[0].Name == "c.txt" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+	ch := make(chan int, len(files))
+	client := NewClient(server.URL, WithRetryPolicy(testRetryPolicy))
+	err := client.UploadFiles(context.Background(), files, ch, WithParallelism(3), WithJournal(path))
+	if err == nil {
+		t.Fatal("expected an error when the server refuses a batch")
+	}
+
+	journal, err := LoadUploadJournal(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	// Batches after the refused one may have been taken, and are journaled
+	// around it
+	if journal.Offset() > 2 {
+		t.Errorf("got journal offset %d, want at most 2", journal.Offset())
+	}
+	for _, missing := range journal.Missing() {
+		if missing.Start <= 2 && missing.End > 2 {
+			return
+		}
+	}
+	t.Errorf("got missing files %v, want them to include the refused batch", journal.Missing())
+}
+
+func TestUploadFilesDeduplicatedParallel(t *testing.T) {
+	files := testFiles(9)
+	for i := range files {
+		// Every file shares its content with another
+		files[i].Data = []byte(strings.Repeat("shared", i%3+1))
+	}
+
+	var mu sync.Mutex
+	stored := make(map[string]bool)
+	manifests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/files/missing-chunks":
+			var hashes [][]byte
+			json.NewDecoder(r.Body).Decode(&hashes)
+			missing := [][]byte{}
+			for _, hash := range hashes {
+				if !stored[string(hash)] {
+					missing = append(missing, hash)
+				}
+			}
+			json.NewEncoder(w).Encode(missing)
+		case r.URL.Path == "/files/upload-chunks":
+			var chunks []chunkUpload
+			json.NewDecoder(r.Body).Decode(&chunks)
+			for _, chunk := range chunks {
+				stored[string(chunk.Hash)] = true
+			}
+		case strings.HasPrefix(r.URL.Path, "/files/upload-manifest-batch/"):
+			var batch []fileManifest
+			json.NewDecoder(r.Body).Decode(&batch)
+			if len(batch) > 1 {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			for _, manifest := range batch {
+				tree, err := merkletree.BuildTree(manifest.Chunks, merkletree.WithHasher(merkletree.SHA256))
+				if err != nil || !bytes.Equal(manifest.Root, tree.RootHash()) {
+					t.Errorf("got manifest root %x for %s, want the root of its chunks", manifest.Root, manifest.Name)
+				}
+			}
+			manifests += len(batch)
+		}
+	}))
+	defer server.Close()
+
+	ch := make(chan int, len(files))
+	client := NewClient(server.URL, WithRetryPolicy(testRetryPolicy))
+	stats, err := client.UploadFilesDeduplicated(context.Background(), files, chunker.DefaultConfig, ch, WithParallelism(4))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if manifests != len(files) {
+		t.Errorf("got %d manifests, want %d", manifests, len(files))
+	}
+	if stats.Chunks != len(files) {
+		t.Errorf("got %d chunks, want %d", stats.Chunks, len(files))
+	}
+	// Batches in flight together may both send a shared chunk
+	if stats.UploadedChunks < 3 || stats.UploadedChunks > stats.Chunks {
+		t.Errorf("got %d uploaded chunks, want between 3 and %d", stats.UploadedChunks, stats.Chunks)
+	}
+}
@@ -0,0 +1,213 @@
+package chunker
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
+)
+
+// Config sets the chunk sizes in bytes. Every chunk except the last is
+// between MinSize and MaxSize, and chunks average around AvgSize.
+type Config struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+var DefaultConfig = Config{
+	MinSize: 2 * 1024,
+	AvgSize: 8 * 1024,
+	MaxSize: 64 * 1024,
+}
+
+// Chunker splits a stream with FastCDC: a gear rolling hash over the last 64
+// bytes decides where chunks end, so a boundary only depends on the bytes
+// just before it. An insert or delete only changes the chunks around the
+// edit, and the rest of the file still dedupes against the old version.
+type Chunker struct {
+	r      io.Reader
+	config Config
+	// maskSmall is harder to match and used before AvgSize, maskLarge is
+	// easier and used after, which pulls chunk sizes towards the average
+	maskSmall uint64
+	maskLarge uint64
+
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+// normalization is how many bits the masks differ from the average chunk
+// size, level 2 in the FastCDC paper
+const normalization = 2
+
+func New(r io.Reader, config Config) (*Chunker, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	avgBits := bits.Len(uint(config.AvgSize)) - 1
+	return &Chunker{
+		r:         r,
+		config:    config,
+		maskSmall: mask(avgBits + normalization),
+		maskLarge: mask(avgBits - normalization),
+		buf:       make([]byte, config.MaxSize),
+	}, nil
+}
+
+func (c Config) validate() error {
+	if c.MinSize <= 0 || c.MinSize > c.AvgSize || c.AvgSize > c.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min <= avg <= max, got %d, %d, %d", c.MinSize, c.AvgSize, c.MaxSize)
+	}
+	if bits.OnesCount(uint(c.AvgSize)) != 1 || c.AvgSize < 1<<(normalization+1) {
+		return fmt.Errorf("average chunk size must be a power of two of at least %d, got %d", 1<<(normalization+1), c.AvgSize)
+	}
+	return nil
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.config.MaxSize && !c.eof {
+		err := c.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill moves the unread bytes to the front of buf and reads until it is
+// full or the reader is done.
+func (c *Chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.config.MinSize {
+		return n
+	}
+	n = min(n, c.config.MaxSize)
+	normal := min(n, c.config.AvgSize)
+
+	var fingerprint uint64
+	i := c.config.MinSize
+	for ; i < normal; i++ {
+		fingerprint = fingerprint<<1 + gear[data[i]]
+		if fingerprint&c.maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fingerprint = fingerprint<<1 + gear[data[i]]
+		if fingerprint&c.maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// mask selects the top n bits of the fingerprint, which depend on the most
+// recent bytes of the window
+func mask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// gear maps each byte to a random value. It must never change, since the
+// server dedupes chunks cut by earlier versions of the client.
+var gear = gearTable()
+
+// gearTable fills the table from splitmix64 with a fixed seed
+func gearTable() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6d65726b6c652d63)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// Split calls fn with every chunk of r in order
+func Split(r io.Reader, config Config, fn func(chunk []byte) error) error {
+	chunker, err := New(r, config)
+	if err != nil {
+		return err
+	}
+
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(chunk)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Root feeds the hash of every chunk of r into a Merkle builder and returns
+// its root, which can be used as the file's leaf. An empty file is a single
+// empty chunk.
+func Root(r io.Reader, config Config, opts ...merkletree.Option) ([]byte, error) {
+	return SplitRoot(r, config, nil, opts...)
+}
+
+// SplitRoot is Root that also passes each chunk and its hash to fn, so a file
+// is split and committed to in one pass
+func SplitRoot(r io.Reader, config Config, fn func(chunk []byte, hash []byte) error, opts ...merkletree.Option) ([]byte, error) {
+	builder := merkletree.NewBuilder(opts...)
+	hasher := builder.Hasher()
+
+	err := Split(r, config, func(chunk []byte) error {
+		hash := merkletree.Sum(hasher, chunk)
+		builder.Append(hash)
+		if fn == nil {
+			return nil
+		}
+		return fn(chunk, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if builder.Size() == 0 {
+		builder.Append(merkletree.Sum(hasher, nil))
+	}
+
+	return builder.Root()
+}
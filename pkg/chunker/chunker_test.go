@@ -0,0 +1,181 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
+)
+
+var testConfig = Config{
+	MinSize: 256,
+	AvgSize: 1024,
+	MaxSize: 4096,
+}
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func split(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	err := Split(bytes.NewReader(data), testConfig, func(chunk []byte) error {
+		chunks = append(chunks, append([]byte{}, chunk...))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	return chunks
+}
+
+func TestSplit(t *testing.T) {
+	for _, size := range []int{0, 1, 255, 256, 4096, 100_000} {
+		data := randomData(1, size)
+		chunks := split(t, data)
+
+		if !bytes.Equal(bytes.Join(chunks, nil), data) {
+			t.Errorf("size %d: chunks don't join back into the data", size)
+		}
+		for i, chunk := range chunks {
+			if len(chunk) > testConfig.MaxSize {
+				t.Errorf("size %d: chunk %d is %d bytes, more than the maximum", size, i, len(chunk))
+			}
+			if i < len(chunks)-1 && len(chunk) < testConfig.MinSize {
+				t.Errorf("size %d: chunk %d is %d bytes, less than the minimum", size, i, len(chunk))
+			}
+		}
+	}
+}
+
+func TestSplitAverageSize(t *testing.T) {
+	data := randomData(2, 1_000_000)
+	chunks := split(t, data)
+
+	average := len(data) / len(chunks)
+	if average < testConfig.AvgSize/2 || average > testConfig.AvgSize*2 {
+		t.Errorf("got average chunk size %d, want about %d", average, testConfig.AvgSize)
+	}
+}
+
+func TestSplitIsStable(t *testing.T) {
+	data := randomData(3, 20_000)
+
+	var lengths []int
+	for _, chunk := range split(t, data) {
+		lengths = append(lengths, len(chunk))
+	}
+
+	// Reading a byte at a time must cut in the same places
+	var oneByteLengths []int
+	err := Split(&oneByteReader{data: data}, testConfig, func(chunk []byte) error {
+		oneByteLengths = append(oneByteLengths, len(chunk))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(oneByteLengths, lengths) {
+		t.Errorf("got %v, want %v", oneByteLengths, lengths)
+	}
+}
+
+func TestSplitAfterInsert(t *testing.T) {
+	data := randomData(4, 200_000)
+	edited := append(append(append([]byte{}, data[:50_000]...), []byte("inserted bytes")...), data[50_000:]...)
+
+	original := make(map[[32]byte]bool)
+	for _, chunk := range split(t, data) {
+		original[sha256.Sum256(chunk)] = true
+	}
+
+	editedChunks := split(t, edited)
+	changed := 0
+	for _, chunk := range editedChunks {
+		if !original[sha256.Sum256(chunk)] {
+			changed++
+		}
+	}
+
+	if changed == 0 || changed > 3 {
+		t.Errorf("expected only the chunks around the insert to change, got %d of %d", changed, len(editedChunks))
+	}
+}
+
+func TestRoot(t *testing.T) {
+	for _, size := range []int{0, 1, 10_000} {
+		data := randomData(5, size)
+
+		var chunkHashes [][]byte
+		for _, chunk := range split(t, data) {
+			chunkHashes = append(chunkHashes, merkletree.Sum(merkletree.SHA3_256, chunk))
+		}
+		if len(chunkHashes) == 0 {
+			chunkHashes = append(chunkHashes, merkletree.Sum(merkletree.SHA3_256, nil))
+		}
+		tree, err := merkletree.BuildTree(chunkHashes, merkletree.WithHasher(merkletree.SHA3_256))
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+
+		got, err := Root(bytes.NewReader(data), testConfig, merkletree.WithHasher(merkletree.SHA3_256))
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, tree.RootHash()) {
+			t.Errorf("size %d: got %x, want %x", size, got, tree.RootHash())
+		}
+
+		var splitHashes [][]byte
+		got, err = SplitRoot(bytes.NewReader(data), testConfig, func(chunk []byte, hash []byte) error {
+			splitHashes = append(splitHashes, hash)
+			return nil
+		}, merkletree.WithHasher(merkletree.SHA3_256))
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, tree.RootHash()) || (size > 0 && !reflect.DeepEqual(splitHashes, chunkHashes)) {
+			t.Errorf("size %d: expected SplitRoot to pass every chunk hash and return the same root", size)
+		}
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "zero", config: Config{}},
+		{name: "min above avg", config: Config{MinSize: 2048, AvgSize: 1024, MaxSize: 4096}},
+		{name: "avg above max", config: Config{MinSize: 256, AvgSize: 8192, MaxSize: 4096}},
+		{name: "avg not a power of two", config: Config{MinSize: 256, AvgSize: 1000, MaxSize: 4096}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(bytes.NewReader(nil), tt.config); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
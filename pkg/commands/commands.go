@@ -2,24 +2,33 @@ package commands
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/manifoldco/promptui"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/api"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/chunker"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/fileutil"
 	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
 )
 
 const (
-	TestFilePath     = "files/dummy"
-	DownloadFilePath = "files/downloads"
-	CorruptFilePath  = "files/corrupt.txt"
+	TestFilePath      = "files/dummy"
+	DownloadFilePath  = "files/downloads"
+	CorruptFilePath   = "files/corrupt.txt"
+	TreeFilePath      = "files/merkle_tree.json"
+	UploadJournalPath = "files/upload_journal.json"
+	SparseRootPath    = "files/sparse_root.json"
 )
 
 func CreateFilesCmd() {
@@ -55,89 +64,257 @@ func CreateFilesCmd() {
 	fmt.Printf("%d test files created in:\n%s/%s %s\n", amount, cwd, TestFilePath, elapsed)
 }
 
-func CreateTreeCmd() {
+// TreeConfig is how "Generate Merkle Tree" hashes the files and builds the
+// tree. A ChunkSize above 0 makes each leaf the root of the file's chunk tree.
+type TreeConfig struct {
+	Hasher       merkletree.Hasher
+	Workers      int
+	ChunkSize    int
+	OddNode      merkletree.OddNodeStrategy
+	LeafEncoding merkletree.LeafEncoding
+}
+
+// CreateTreeCmd hashes every file and builds the tree from scratch. Refresh
+// Merkle Tree only hashes the files that changed.
+func CreateTreeCmd(config TreeConfig) *merkletree.MerkleTree {
 	fileutil.MakeDir(TestFilePath)
 
-	chLoading, chCount := startLoadingWithCount("Reading %d files", 0)
 	start := time.Now()
-	files := fileutil.GetFiles(TestFilePath, chCount)
+	dir, err := fileutil.ListDir(TestFilePath)
+	if err != nil {
+		fmt.Println("Error listing test files:", err)
+		return nil
+	}
 	elapsed := time.Since(start)
-	endLoadingWithCount(chLoading, chCount)
-	fmt.Printf("Files read %s\n", elapsed)
+	fmt.Printf("%d test files listed %s\n", dir.Len(), elapsed)
 
-	if len(files) < 1 {
+	if dir.Len() < 1 {
 		fmt.Println("Please create some test files first.")
-		return
+		return nil
 	}
 
-	chLoading = startLoading("Hashing files")
+	chLoading, chCount := startLoadingWithCount("Hashing %d/%d files", dir.Len())
 	start = time.Now()
-	var fileHashes [][]byte
-	for _, file := range files {
-		fileHash := sha256.Sum256([]byte(file.Data))
-		fileHashes = append(fileHashes, fileHash[:])
+	hashOpts := []merkletree.Option{
+		merkletree.WithHasher(config.Hasher),
+		merkletree.WithOddNodeStrategy(config.OddNode),
 	}
-	sort.Slice(fileHashes, func(i int, j int) bool {
-		return bytes.Compare(fileHashes[i], fileHashes[j]) < 0
-	})
+	hashFile := func(r io.Reader) ([]byte, error) {
+		return merkletree.FileHash(r, config.ChunkSize, hashOpts...)
+	}
+	fileHashes, err := dir.HashFiles(hashFile, config.Workers, chCount)
+	endLoadingWithCount(chLoading, chCount)
+	if err != nil {
+		fmt.Println("Error hashing test files:", err)
+		return nil
+	}
+	if config.LeafEncoding == merkletree.LeafEncodingMetadata {
+		for i, name := range dir.Names {
+			metadata, err := fileMetadata(name)
+			if err != nil {
+				fmt.Println("Error reading test file:", err)
+				return nil
+			}
+			fileHashes[i] = merkletree.MetadataLeaf(config.Hasher, metadata, fileHashes[i])
+		}
+	}
+	fileHashes, fileNames := sortByHash(fileHashes, dir.Names)
 	elapsed = time.Since(start)
-	endLoading(chLoading)
 	fmt.Printf("Files hashed %s\n", elapsed)
 
 	chLoading = startLoading("Building tree")
 	start = time.Now()
-	merkletree.BuildTree(fileHashes)
-	rootHash := hex.EncodeToString(merkletree.Root.Hash[:])
+	tree, err := merkletree.BuildTree(
+		fileHashes,
+		append(
+			hashOpts,
+			merkletree.WithLeafOrder(merkletree.LeafOrderSortedHash),
+			merkletree.WithWorkers(config.Workers),
+			merkletree.WithChunkSize(config.ChunkSize),
+			merkletree.WithLeafEncoding(config.LeafEncoding),
+			merkletree.WithLeafNames(fileNames),
+		)...,
+	)
 	elapsed = time.Since(start)
 	endLoading(chLoading)
+	if err != nil {
+		fmt.Println("Error building Merkle tree:", err)
+		return nil
+	}
 	fmt.Printf("Generated Merkle tree %s\n", elapsed)
 
+	rootHash := hex.EncodeToString(tree.RootHash())
 	fmt.Printf("Root hash: %s\n", rootHash)
+
+	saveTree(tree)
+	return tree
+}
+
+// RefreshTreeCmd brings the tree up to date with files added, removed or
+// modified since it was last saved. Only those files are hashed, and each
+// change only rehashes its leaf's path to the root.
+func RefreshTreeCmd(tree *merkletree.MerkleTree, workers int) {
+	if tree == nil {
+		fmt.Println("You need to Generate a Merkle tree first.")
+		return
+	}
+	if !tree.HasLeafNames() {
+		fmt.Println("The Merkle tree was generated without file names, please Generate Merkle Tree again.")
+		return
+	}
+	if tree.LeafOrder != merkletree.LeafOrderSortedHash {
+		fmt.Println("The Merkle tree's files aren't in hash order, please Generate Merkle Tree again.")
+		return
+	}
+
+	start := time.Now()
+	dir, err := fileutil.ListDir(TestFilePath)
+	if err != nil {
+		fmt.Println("Error listing test files:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("%d test files listed %s\n", dir.Len(), elapsed)
+
+	if !updateChangedFiles(tree, dir.Names, workers) {
+		return
+	}
+	saveTree(tree)
 }
 
-func UploadFilesCmd(serverURL string) {
+// LoadTreeCmd loads the tree saved by the last "Generate Merkle Tree", if any
+func LoadTreeCmd() *merkletree.MerkleTree {
+	if _, err := os.Stat(TreeFilePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	start := time.Now()
+	tree, err := merkletree.LoadFile(TreeFilePath)
+	if err != nil {
+		fmt.Println("Error loading Merkle tree:", err)
+		return nil
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("Loaded Merkle tree of %d files created %s %s\n", tree.LeafCount, tree.CreatedAt.Local().Format(time.DateTime), elapsed)
+	fmt.Printf("Root hash: %s\n\n", hex.EncodeToString(tree.RootHash()))
+	return tree
+}
+
+// UploadFilesCmd with deduplicate set only uploads the content-defined chunks
+// the server doesn't already have.
+func UploadFilesCmd(client *api.Client, hasher merkletree.Hasher, deduplicate bool, workers int) {
+	ctx, stop := commandContext()
+	defer stop()
+
 	fileutil.MakeDir(TestFilePath)
 
-	chLoading, chCount := startLoadingWithCount("Reading %d files", 0)
 	start := time.Now()
-	files := fileutil.GetFiles(TestFilePath, chCount)
+	dir, err := fileutil.ListDir(TestFilePath)
+	if err != nil {
+		fmt.Println("Error listing test files:", err)
+		return
+	}
 	elapsed := time.Since(start)
-	endLoadingWithCount(chLoading, chCount)
-	fmt.Printf("%d test files read %s\n", len(files), elapsed)
+	fmt.Printf("%d test files listed %s\n", dir.Len(), elapsed)
 
-	if len(files) < 1 {
+	if dir.Len() < 1 {
 		fmt.Println("Please create some test files first.")
 		return
 	}
 
-	err := api.DeleteAllFiles(serverURL)
+	err = client.DeleteAllFiles(ctx)
 	if err != nil {
-		fmt.Println("Error deleting files in the DB:", err)
+		printServerError("Error deleting files in the DB:", err)
 		return
 	}
 	fmt.Println("Deleted all files in the DB!")
+	os.Remove(SparseRootPath)
+
+	chLoading, chCount := startLoadingWithCount("Uploading %d files", 0)
+	start = time.Now()
+	var stats api.UploadStats
+	if deduplicate {
+		stats, err = client.UploadFilesDeduplicated(ctx, dir, chunker.DefaultConfig, chCount, api.WithJournal(UploadJournalPath), api.WithParallelism(workers))
+	} else {
+		err = client.UploadFiles(ctx, dir, chCount, api.WithJournal(UploadJournalPath), api.WithParallelism(workers))
+	}
+	elapsed = time.Since(start)
+	endLoadingWithCount(chLoading, chCount)
+	if err != nil {
+		printServerError("Error sending the files to the server:", err)
+		fmt.Println("Run Resume Upload to carry on from the last batch the server took.")
+		return
+	}
+	os.Remove(UploadJournalPath)
+
+	fmt.Printf("Uploaded %d files! %s\n", dir.Len(), elapsed)
+	if deduplicate {
+		fmt.Printf("Sent %d of %d chunks (%d of %d bytes)\n", stats.UploadedChunks, stats.Chunks, stats.UploadedBytes, stats.Bytes)
+	}
+	fmt.Printf("IDs range from 1 to %d\n", dir.Len())
+	saveSparseRoot(dir, hasher, workers)
+}
+
+// ResumeUploadCmd carries on an upload that failed or was interrupted, even
+// by the process exiting, without deleting what the server already has
+func ResumeUploadCmd(client *api.Client, hasher merkletree.Hasher, workers int) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	journal, err := api.LoadUploadJournal(UploadJournalPath)
+	if os.IsNotExist(err) {
+		fmt.Println("There is no unfinished upload to resume.")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error loading upload journal:", err)
+		return
+	}
+
+	start := time.Now()
+	dir, err := fileutil.ListDir(TestFilePath)
+	if err != nil {
+		fmt.Println("Error listing test files:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("%d test files listed %s\n", dir.Len(), elapsed)
+	fmt.Printf("Resuming upload with %d of %d files already uploaded\n", journal.Uploaded(), journal.FileCount)
 
-	chLoading, chCount = startLoadingWithCount("Uploading %d files", 0)
+	chLoading, chCount := startLoadingWithCount("Uploading %d files", 0)
 	start = time.Now()
-	err = api.UploadFiles(serverURL, files, chCount)
+	stats, err := client.ResumeUpload(ctx, dir, UploadJournalPath, chCount, api.WithParallelism(workers))
 	elapsed = time.Since(start)
 	endLoadingWithCount(chLoading, chCount)
 	if err != nil {
-		fmt.Println("Error sending the files to the server:", err)
+		printServerError("Error resuming the upload:", err)
 		return
 	}
+	os.Remove(UploadJournalPath)
 
-	fmt.Printf("Uploaded %d files! %s\n", len(files), elapsed)
-	fmt.Printf("IDs range from 1 to %d\n", len(files))
+	fmt.Printf("Uploaded %d files! %s\n", dir.Len(), elapsed)
+	if journal.Chunker != nil {
+		fmt.Printf("Sent %d of %d chunks (%d of %d bytes)\n", stats.UploadedChunks, stats.Chunks, stats.UploadedBytes, stats.Bytes)
+	}
+	fmt.Printf("IDs range from 1 to %d\n", dir.Len())
+	saveSparseRoot(dir, hasher, workers)
 }
 
-func DownloadAndVerifyFileCmd(serverURL string) {
+func DownloadAndVerifyFileCmd(client *api.Client, tree *merkletree.MerkleTree) {
+	ctx, stop := commandContext()
+	defer stop()
+
 	fileutil.MakeDir(DownloadFilePath)
 
-	if merkletree.Root == nil {
+	if tree == nil {
 		fmt.Println("You need to Generate a Merkle tree first.")
 		return
 	}
+	if !tree.HasLeafNames() {
+		fmt.Println("The Merkle tree was generated without file names, please Generate Merkle Tree again.")
+		return
+	}
 
 	prompt := promptui.Prompt{
 		Label: "Enter file id",
@@ -154,22 +331,27 @@ func DownloadAndVerifyFileCmd(serverURL string) {
 	}
 
 	start := time.Now()
-	fileName, fileData, err := api.GetFile(serverURL, input)
+	file, err := client.GetFile(ctx, input)
+	if errors.Is(err, api.ErrNotFound) {
+		fmt.Println("No file found for id:", input)
+		return
+	}
 	if err != nil {
-		fmt.Println("Error getting file with id:", input, ":", err)
+		printServerError("Error getting file with id: "+input+" :", err)
 		return
 	}
+	fileName := file.Name
 
 	filePath := DownloadFilePath + "/" + fileName
-	err = os.WriteFile(filePath, fileData, 0644)
+	err = os.WriteFile(filePath, file.Data, 0644)
 	if err != nil {
 		fmt.Println("Error getting file with id:", input, ":", err)
 		return
 	}
 
-	proof, err := api.GetProof(serverURL, input)
+	proof, err := client.GetProof(ctx, input)
 	if err != nil {
-		fmt.Println("Error getting proof:", err)
+		printServerError("Error getting proof:", err)
 		return
 	}
 
@@ -177,11 +359,25 @@ func DownloadAndVerifyFileCmd(serverURL string) {
 	cwd, _ := os.Getwd()
 	fmt.Printf("Downloaded file %s and proof to:\n%s/%s %s\n", input, cwd, filePath, elapsed)
 
-	fileHash := sha256.Sum256(fileData)
+	fileHash, err := downloadedLeaf(tree, file)
+	if err != nil {
+		fmt.Println("Error hashing file:", err)
+		return
+	}
+
+	// The proof is placed at the file's own leaf, so the server's leaf
+	// index and sibling flags are never trusted
+	index, ok := tree.LeafIndex(fileName)
+	if !ok {
+		fmt.Printf("%s is not in the Merkle tree\n", fileName)
+		return
+	}
+	proof.LeafIndex = index
+	proof.TreeSize = tree.LeafCount
 
 	start = time.Now()
-	isVerified, proofRoot := merkletree.VerifyMerkleProof(merkletree.Root.Hash, fileHash[:], proof)
-	rootHash := hex.EncodeToString(merkletree.Root.Hash)
+	isVerified, proofRoot := tree.VerifyProof(fileHash, proof)
+	rootHash := hex.EncodeToString(tree.RootHash())
 	proofRootHash := hex.EncodeToString(proofRoot)
 	elapsed = time.Since(start)
 	fmt.Println("New root generated with Merkle proof!", elapsed)
@@ -190,11 +386,478 @@ func DownloadAndVerifyFileCmd(serverURL string) {
 	if isVerified {
 		fmt.Printf("The hashes match!\n%s has not been modified\n", fileName)
 	} else {
-		fmt.Printf("The hashes don't match!\n%s has been corrupted\n", fileName)
+		fmt.Printf("The hashes don't match!\n%s has been corrupted or served under the wrong name\n", fileName)
+	}
+}
+
+func DownloadAndVerifyFilesCmd(client *api.Client, tree *merkletree.MerkleTree) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	fileutil.MakeDir(DownloadFilePath)
+
+	if tree == nil {
+		fmt.Println("You need to Generate a Merkle tree first.")
+		return
+	}
+	if !tree.HasLeafNames() {
+		fmt.Println("The Merkle tree was generated without file names, please Generate Merkle Tree again.")
+		return
+	}
+
+	prompt := promptui.Prompt{
+		Label: "Enter file ids (e.g. 1-100,150)",
+	}
+	input, err := prompt.Run()
+	if err != nil {
+		fmt.Println("Error with prompt:", err)
+		return
+	}
+	ids, err := parseIDs(input)
+	if err != nil {
+		fmt.Println("Please enter integers or ranges:", err)
+		return
+	}
+
+	chLoading, chCount := startLoadingWithCount("Downloading %d/%d files", len(ids))
+	start := time.Now()
+	var fileHashes [][]byte
+	var leafIndices []int
+	for i, id := range ids {
+		file, err := client.GetFile(ctx, id)
+		if errors.Is(err, api.ErrNotFound) {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Println("No file found for id:", id)
+			return
+		}
+		if err != nil {
+			endLoadingWithCount(chLoading, chCount)
+			printServerError("Error getting file with id: "+id+" :", err)
+			return
+		}
+
+		err = os.WriteFile(DownloadFilePath+"/"+file.Name, file.Data, 0644)
+		if err != nil {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Println("Error getting file with id:", id, ":", err)
+			return
+		}
+
+		fileHash, err := downloadedLeaf(tree, file)
+		if err != nil {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Println("Error hashing file with id:", id, ":", err)
+			return
+		}
+		index, ok := tree.LeafIndex(file.Name)
+		if !ok {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Printf("%s is not in the Merkle tree\n", file.Name)
+			return
+		}
+		fileHashes = append(fileHashes, fileHash)
+		leafIndices = append(leafIndices, index)
+		chCount <- i + 1
+	}
+	endLoadingWithCount(chLoading, chCount)
+
+	proof, err := client.GetMultiProof(ctx, ids)
+	if err != nil {
+		printServerError("Error getting multiproof:", err)
+		return
+	}
+	// Each file is placed at its own leaf, so the server's leaf indices are
+	// never trusted
+	proof.LeafIndices = leafIndices
+
+	elapsed := time.Since(start)
+	cwd, _ := os.Getwd()
+	fmt.Printf("Downloaded %d files and multiproof to:\n%s/%s %s\n", len(ids), cwd, DownloadFilePath, elapsed)
+
+	start = time.Now()
+	isVerified, proofRoot := tree.VerifyMultiProof(fileHashes, proof)
+	rootHash := hex.EncodeToString(tree.RootHash())
+	proofRootHash := hex.EncodeToString(proofRoot)
+	elapsed = time.Since(start)
+	fmt.Println("New root generated with Merkle multiproof!", elapsed)
+	fmt.Printf("Stored root hash: %s\n", rootHash)
+	fmt.Printf("Proof root hash:  %s\n", proofRootHash)
+	if isVerified {
+		fmt.Printf("The hashes match!\nNone of the %d files have been modified\n", len(ids))
+	} else {
+		fmt.Printf("The hashes don't match!\nAt least one of the %d files has been corrupted\n", len(ids))
 	}
 }
 
-func CorruptFileCmd(serverURL string) {
+// DownloadAndVerifyChunksCmd downloads a file one chunk at a time into a
+// .part file, verifying each chunk before keeping it. Chunks left in the .part
+// file by an earlier attempt are verified again, so an interrupted download
+// resumes from the first chunk that is missing or doesn't verify.
+func DownloadAndVerifyChunksCmd(client *api.Client, tree *merkletree.MerkleTree) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	fileutil.MakeDir(DownloadFilePath)
+
+	if tree == nil {
+		fmt.Println("You need to Generate a Merkle tree first.")
+		return
+	}
+	if tree.ChunkSize == 0 {
+		fmt.Println("The Merkle tree was generated without chunks, set CHUNK_SIZE and generate it again.")
+		return
+	}
+	if tree.LeafEncoding == merkletree.LeafEncodingMetadata {
+		fmt.Println("Chunk proofs don't cover file names, set LEAF_ENCODING=content and generate the tree again.")
+		return
+	}
+	if !tree.HasLeafNames() {
+		fmt.Println("The Merkle tree was generated without file names, please Generate Merkle Tree again.")
+		return
+	}
+
+	prompt := promptui.Prompt{
+		Label: "Enter file id",
+	}
+	input, err := prompt.Run()
+	if err != nil {
+		fmt.Println("Error with prompt:", err)
+		return
+	}
+	_, err = strconv.Atoi(input)
+	if err != nil {
+		fmt.Println("Please enter an integer:", err)
+		return
+	}
+
+	partPath := DownloadFilePath + "/" + input + ".part"
+	partFile, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("Error opening partial download:", err)
+		return
+	}
+	defer partFile.Close()
+
+	start := time.Now()
+	// The file's name picks its leaf, so the server's leaf index and sibling
+	// flags are never trusted
+	fileName, firstChunk, err := client.GetChunk(ctx, input, 0)
+	if err != nil {
+		printServerError(fmt.Sprintf("Error getting chunk 0 of file with id: %s :", input), err)
+		return
+	}
+	leafIndex, ok := tree.LeafIndex(fileName)
+	if !ok {
+		fmt.Printf("%s is not in the Merkle tree\n", fileName)
+		return
+	}
+	getChunkProof := func(index int) (merkletree.ChunkProof, error) {
+		proof, err := client.GetChunkProof(ctx, input, index)
+		proof.File.LeafIndex = leafIndex
+		proof.File.TreeSize = tree.LeafCount
+		return proof, err
+	}
+
+	proof, err := getChunkProof(0)
+	if err != nil {
+		printServerError("Error getting chunk proof:", err)
+		return
+	}
+	chunkCount := proof.ChunkCount()
+
+	// The last chunk is always downloaded again as it may be incomplete
+	resumed := 0
+	chunk := make([]byte, tree.ChunkSize)
+	for resumed < chunkCount-1 {
+		_, err = io.ReadFull(partFile, chunk)
+		if err != nil {
+			break
+		}
+		proof, err = getChunkProof(resumed)
+		if err != nil {
+			printServerError("Error getting chunk proof:", err)
+			return
+		}
+		if valid, _ := tree.VerifyChunkProof(chunk, proof); !valid {
+			break
+		}
+		resumed++
+	}
+
+	offset := int64(resumed) * int64(tree.ChunkSize)
+	err = partFile.Truncate(offset)
+	if err == nil {
+		_, err = partFile.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		fmt.Println("Error resuming partial download:", err)
+		return
+	}
+	if resumed > 0 {
+		fmt.Printf("Resuming after %d verified chunks\n", resumed)
+	}
+
+	chLoading, chCount := startLoadingWithCount("Downloading chunk %d/%d", chunkCount)
+	for i := resumed; i < chunkCount; i++ {
+		data := firstChunk
+		if i > 0 {
+			var name string
+			name, data, err = client.GetChunk(ctx, input, i)
+			if err == nil && name != fileName {
+				err = fmt.Errorf("server named the file %s, not %s", name, fileName)
+			}
+			if err != nil {
+				endLoadingWithCount(chLoading, chCount)
+				printServerError(fmt.Sprintf("Error getting chunk %d of file with id: %s :", i, input), err)
+				return
+			}
+		}
+
+		proof, err = getChunkProof(i)
+		if err != nil {
+			endLoadingWithCount(chLoading, chCount)
+			printServerError("Error getting chunk proof:", err)
+			return
+		}
+
+		if valid, _ := tree.VerifyChunkProof(data, proof); !valid {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Printf("The hashes don't match!\nChunk %d of %s has been corrupted\n", i, fileName)
+			fmt.Printf("Kept the %d chunks before it in %s\n", i, partPath)
+			return
+		}
+
+		_, err = partFile.Write(data)
+		if err != nil {
+			endLoadingWithCount(chLoading, chCount)
+			fmt.Println("Error writing chunk", i, ":", err)
+			return
+		}
+		chCount <- i + 1
+	}
+	endLoadingWithCount(chLoading, chCount)
+
+	err = partFile.Close()
+	if err == nil {
+		err = os.Rename(partPath, DownloadFilePath+"/"+fileName)
+	}
+	if err != nil {
+		fmt.Println("Error saving downloaded file:", err)
+		return
+	}
+
+	elapsed := time.Since(start)
+	cwd, _ := os.Getwd()
+	fmt.Printf("Downloaded and verified %d chunks of file %s to:\n%s/%s/%s %s\n", chunkCount, input, cwd, DownloadFilePath, fileName, elapsed)
+	fmt.Printf("The hashes match!\n%s has not been modified\n", fileName)
+}
+
+func CheckConsistencyCmd(client *api.Client, tree *merkletree.MerkleTree) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	if tree == nil {
+		fmt.Println("You need to Generate a Merkle tree first.")
+		return
+	}
+
+	start := time.Now()
+	proof, serverRoot, err := client.GetConsistencyProof(ctx, tree.LeafCount)
+	if err != nil {
+		printServerError("Error getting consistency proof:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Downloaded consistency proof for %d to %d files %s\n", proof.OldSize, proof.NewSize, elapsed)
+
+	start = time.Now()
+	isConsistent := tree.VerifyConsistencyProof(serverRoot, proof)
+	elapsed = time.Since(start)
+	fmt.Println("Consistency proof checked!", elapsed)
+	fmt.Printf("Stored root hash: %s\n", hex.EncodeToString(tree.RootHash()))
+	fmt.Printf("Server root hash: %s\n", hex.EncodeToString(serverRoot))
+	if isConsistent {
+		fmt.Printf("The server's tree only appends to the stored tree!\nAll %d stored files are unchanged\n", tree.LeafCount)
+	} else {
+		fmt.Println("The server's tree is not an extension of the stored tree!\nStored files have been changed or removed")
+	}
+}
+
+// CompareWithServerCmd rebuilds the server's tree from the hashes of its
+// files and diffs it against the stored tree to find the files that changed
+func CompareWithServerCmd(client *api.Client, tree *merkletree.MerkleTree) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	if tree == nil {
+		fmt.Println("You need to Generate a Merkle tree first.")
+		return
+	}
+	if !tree.HasLeafNames() {
+		fmt.Println("The Merkle tree was generated without file names, please Generate Merkle Tree again.")
+		return
+	}
+
+	start := time.Now()
+	serverTree, diffs, err := compareWithServer(ctx, client, tree)
+	if err != nil {
+		printServerError("Error comparing with the server:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Println("Merkle trees compared!", elapsed)
+	fmt.Printf("Stored root hash: %s\n", hex.EncodeToString(tree.RootHash()))
+	fmt.Printf("Server root hash: %s\n", hex.EncodeToString(serverTree.RootHash()))
+
+	if len(diffs) == 0 {
+		fmt.Printf("The hashes match!\nAll %d files are the same on the server\n", tree.LeafCount)
+		return
+	}
+
+	fmt.Printf("The hashes don't match!\n%d files differ on the server:\n", len(diffs))
+	for _, diff := range diffs {
+		name := diff.Name
+		if name == "" {
+			name = "unknown file"
+		}
+		switch diff.Kind {
+		case merkletree.LeafChanged:
+			fmt.Printf("  %d: %s has been modified\n", diff.Index, name)
+		case merkletree.LeafAdded:
+			fmt.Printf("  %d: %s is only on the server\n", diff.Index, name)
+		case merkletree.LeafRemoved:
+			fmt.Printf("  %d: %s is missing from the server\n", diff.Index, name)
+		}
+	}
+}
+
+// compareWithServer builds a tree from the server's file hashes with each
+// file at its index in tree, so only files that differ are reported whatever
+// order the server keeps them in. A file the server doesn't have gets an
+// empty leaf in its place, and files only the server has go after the rest.
+func compareWithServer(ctx context.Context, client *api.Client, tree *merkletree.MerkleTree) (*merkletree.MerkleTree, []merkletree.LeafDiff, error) {
+	files, err := client.GetFileHashes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverHashes := make(map[string][]byte, len(files))
+	var extra []api.FileHash
+	for _, file := range files {
+		if _, ok := serverHashes[file.Name]; ok {
+			return nil, nil, fmt.Errorf("server has %s more than once", file.Name)
+		}
+		serverHashes[file.Name] = file.Hash
+		if _, ok := tree.LeafIndex(file.Name); !ok {
+			extra = append(extra, file)
+		}
+	}
+
+	hashes := make([][]byte, 0, tree.LeafCount+len(extra))
+	names := make([]string, 0, tree.LeafCount+len(extra))
+	missing := make(map[int]bool)
+	for i := 0; i < tree.LeafCount; i++ {
+		name, err := tree.LeafName(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, ok := serverHashes[name]
+		if !ok {
+			hash = make([]byte, tree.Hasher.New().Size())
+			missing[i] = true
+		}
+		hashes = append(hashes, hash)
+		names = append(names, name)
+	}
+	for _, file := range extra {
+		hashes = append(hashes, file.Hash)
+		names = append(names, file.Name)
+	}
+
+	serverTree, err := merkletree.BuildTree(hashes, append(tree.Options(), merkletree.WithLeafNames(names))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	diffs, err := merkletree.Diff(tree, serverTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range diffs {
+		if missing[diffs[i].Index] {
+			diffs[i].Kind = merkletree.LeafRemoved
+		}
+	}
+	return serverTree, diffs, nil
+}
+
+// VerifyFileDeletedCmd checks the server's proof against the sparse root the
+// client stored after its last upload, and fails unless the server's root is
+// that same root
+func VerifyFileDeletedCmd(client *api.Client, hasher merkletree.Hasher) {
+	ctx, stop := commandContext()
+	defer stop()
+
+	stored, err := loadSparseRoot()
+	if os.IsNotExist(err) {
+		fmt.Println("There is no stored sparse root, please Upload Test Files first.")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error loading sparse root:", err)
+		return
+	}
+	if stored.Algorithm != hasher.Name() {
+		fmt.Printf("The stored sparse root uses %s but the client uses %s, please Upload Test Files again.\n", stored.Algorithm, hasher.Name())
+		return
+	}
+
+	prompt := promptui.Prompt{
+		Label: "Enter file name",
+	}
+	fileName, err := prompt.Run()
+	if err != nil {
+		fmt.Println("Error with prompt:", err)
+		return
+	}
+
+	start := time.Now()
+	proof, serverRoot, err := client.GetSparseProof(ctx, fileName)
+	if err != nil {
+		printServerError("Error getting sparse proof:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Downloaded sparse proof for %s %s\n", fileName, elapsed)
+
+	if proof.Algorithm != hasher.Name() {
+		fmt.Printf("The server's proof uses %s but the client uses %s\n", proof.Algorithm, hasher.Name())
+		return
+	}
+
+	start = time.Now()
+	isAbsent, proofRoot := merkletree.VerifySparseProof(stored.Root, fileName, nil, proof)
+	elapsed = time.Since(start)
+	fmt.Println("New root generated with sparse Merkle proof!", elapsed)
+	fmt.Printf("Stored root hash: %s\n", hex.EncodeToString(stored.Root))
+	fmt.Printf("Server root hash: %s\n", hex.EncodeToString(serverRoot))
+	fmt.Printf("Proof root hash:  %s\n", hex.EncodeToString(proofRoot))
+	// An absence proof against the stored root only says something about
+	// the server when the server committed to that same root
+	if !bytes.Equal(serverRoot, stored.Root) {
+		fmt.Printf("The server's root doesn't match the stored root!\nThe server's committed files have changed since the last upload, so %s can't be verified\n", fileName)
+		return
+	}
+	if isAbsent {
+		fmt.Printf("The hashes match!\n%s is not in the committed files\n", fileName)
+	} else {
+		fmt.Printf("The hashes don't match!\n%s has not been deleted from the server\n", fileName)
+	}
+}
+
+func CorruptFileCmd(client *api.Client) {
+	ctx, stop := commandContext()
+	defer stop()
+
 	prompt := promptui.Prompt{
 		Label: "Enter file id",
 	}
@@ -216,9 +879,13 @@ func CorruptFileCmd(serverURL string) {
 		return
 	}
 
-	err = api.CorruptFile(serverURL, input, file)
+	err = client.CorruptFile(ctx, input, file)
+	if errors.Is(err, api.ErrNotFound) {
+		fmt.Println("No file found for id:", input)
+		return
+	}
 	if err != nil {
-		fmt.Println("Error corrupting file in DB:", err)
+		printServerError("Error corrupting file in DB:", err)
 		return
 	}
 	elapsed := time.Since(start)
@@ -249,6 +916,237 @@ func ExitCmd() {
 	os.Exit(0)
 }
 
+// parseIDs expands input such as "1-3,7" into ["1" "2" "3" "7"]
+func parseIDs(input string) ([]string, error) {
+	var ids []string
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid range: %s", part)
+		}
+
+		for id := start; id <= end; id++ {
+			ids = append(ids, strconv.Itoa(id))
+		}
+	}
+
+	return ids, nil
+}
+
+// sortByHash sorts the file hashes, keeping each name with its hash
+func sortByHash(hashes [][]byte, names []string) ([][]byte, []string) {
+	order := make([]int, len(hashes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i int, j int) bool {
+		return bytes.Compare(hashes[order[i]], hashes[order[j]]) < 0
+	})
+
+	sortedHashes := make([][]byte, len(hashes))
+	sortedNames := make([]string, len(names))
+	for i, j := range order {
+		sortedHashes[i] = hashes[j]
+		sortedNames[i] = names[j]
+	}
+	return sortedHashes, sortedNames
+}
+
+// updateChangedFiles hashes only the files added or modified since the tree
+// was last saved, matched by name and modification time, and moves them into
+// the tree in hash order. It reports whether the tree was updated.
+func updateChangedFiles(tree *merkletree.MerkleTree, names []string, workers int) bool {
+	info, err := os.Stat(TreeFilePath)
+	if err != nil {
+		fmt.Println("Error reading saved Merkle tree:", err)
+		return false
+	}
+	savedAt := info.ModTime()
+
+	start := time.Now()
+	present := make(map[string]bool, len(names))
+	var added, modified []string
+	for _, name := range names {
+		present[name] = true
+		if _, ok := tree.LeafIndex(name); !ok {
+			added = append(added, name)
+			continue
+		}
+
+		info, err := os.Stat(TestFilePath + "/" + name)
+		if err != nil {
+			fmt.Println("Error reading test file:", err)
+			return false
+		}
+		if info.ModTime().After(savedAt) {
+			modified = append(modified, name)
+		}
+	}
+
+	var removed []string
+	for i := 0; i < tree.LeafCount; i++ {
+		name, _ := tree.LeafName(i)
+		if !present[name] {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) == tree.LeafCount && len(added) == 0 {
+		fmt.Println("Please create some test files first.")
+		return false
+	}
+
+	// Hash everything before changing the tree so an error leaves it as it was
+	changed := &fileutil.Dir{Path: TestFilePath, Names: append(added, modified...)}
+	var fileHashes [][]byte
+	if changed.Len() > 0 {
+		chLoading, chCount := startLoadingWithCount("Hashing %d/%d files", changed.Len())
+		var err error
+		fileHashes, err = changed.HashFiles(tree.FileHash, workers, chCount)
+		endLoadingWithCount(chLoading, chCount)
+		if err != nil {
+			fmt.Println("Error hashing test files:", err)
+			return false
+		}
+	}
+	hashes := make(map[string][]byte, changed.Len())
+	for i, name := range changed.Names {
+		metadata, err := fileMetadata(name)
+		if err != nil {
+			fmt.Println("Error reading test file:", err)
+			return false
+		}
+		hashes[name] = tree.EncodeLeaf(metadata, fileHashes[i])
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Found %d new, %d modified and %d deleted files %s\n", len(added), len(modified), len(removed), elapsed)
+
+	start = time.Now()
+	err = tree.ApplySorted(hashes, removed)
+	if err != nil {
+		fmt.Println("Error updating Merkle tree:", err)
+		return false
+	}
+	elapsed = time.Since(start)
+	fmt.Printf("Merkle tree updated %s\n", elapsed)
+
+	rootHash := hex.EncodeToString(tree.RootHash())
+	fmt.Printf("Root hash: %s\n", rootHash)
+	return true
+}
+
+// sparseRoot is the root of the sparse Merkle tree of the files the client
+// last uploaded
+type sparseRoot struct {
+	Algorithm string
+	Root      []byte
+}
+
+// saveSparseRoot builds the sparse Merkle tree of file names the server
+// should now have, with each file's hash as its value, and stores its root to
+// check the server's sparse proofs against
+func saveSparseRoot(dir *fileutil.Dir, hasher merkletree.Hasher, workers int) {
+	chLoading, chCount := startLoadingWithCount("Hashing %d/%d files", dir.Len())
+	start := time.Now()
+	fileHashes, err := dir.HashFiles(func(r io.Reader) ([]byte, error) {
+		return merkletree.FileHash(r, 0, merkletree.WithHasher(hasher))
+	}, workers, chCount)
+	endLoadingWithCount(chLoading, chCount)
+	if err != nil {
+		fmt.Println("Error hashing test files:", err)
+		return
+	}
+
+	tree := merkletree.NewSparseMerkleTree(merkletree.WithHasher(hasher))
+	for i, name := range dir.Names {
+		tree.Set(name, fileHashes[i])
+	}
+	data, err := json.Marshal(sparseRoot{Algorithm: hasher.Name(), Root: tree.RootHash()})
+	if err == nil {
+		err = os.WriteFile(SparseRootPath, data, 0644)
+	}
+	if err != nil {
+		fmt.Println("Error saving sparse root:", err)
+		return
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Sparse root stored %s\n", elapsed)
+}
+
+func loadSparseRoot() (sparseRoot, error) {
+	var stored sparseRoot
+	data, err := os.ReadFile(SparseRootPath)
+	if err != nil {
+		return stored, err
+	}
+	err = json.Unmarshal(data, &stored)
+	return stored, err
+}
+
+func fileMetadata(name string) (merkletree.FileMetadata, error) {
+	info, err := os.Stat(TestFilePath + "/" + name)
+	if err != nil {
+		return merkletree.FileMetadata{}, err
+	}
+	return merkletree.FileMetadata{Path: name, Size: info.Size(), Mode: info.Mode().Perm()}, nil
+}
+
+// downloadedLeaf hashes a downloaded file under the name and mode the server
+// sent it with, so a file served under the wrong name doesn't verify
+func downloadedLeaf(tree *merkletree.MerkleTree, file fileutil.File) ([]byte, error) {
+	if tree.LeafEncoding == merkletree.LeafEncodingMetadata && file.Mode == 0 {
+		return nil, errors.New("the server didn't send the file's mode, which the Merkle tree's leaves include")
+	}
+
+	metadata := merkletree.FileMetadata{Path: file.Name, Size: int64(len(file.Data)), Mode: file.Mode}
+	return tree.FileLeaf(bytes.NewReader(file.Data), metadata)
+}
+
+// printServerError prints message and err, explaining the server errors a
+// user can do something about
+func printServerError(message string, err error) {
+	fmt.Println(message, err)
+	switch {
+	case errors.Is(err, api.ErrUnauthorized):
+		fmt.Println("The server rejected the request, check the client is allowed to access it.")
+	case errors.Is(err, api.ErrServerBusy):
+		fmt.Println("The server is busy, please try again shortly.")
+	case errors.Is(err, context.Canceled):
+		fmt.Println("The request was cancelled.")
+	}
+}
+
+// commandContext is cancelled by Ctrl+C, so a slow request can be abandoned
+// without quitting
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func saveTree(tree *merkletree.MerkleTree) {
+	start := time.Now()
+	err := tree.SaveFile(TreeFilePath, merkletree.StoreLeaves)
+	if err != nil {
+		fmt.Println("Error saving Merkle tree:", err)
+		return
+	}
+	elapsed := time.Since(start)
+
+	cwd, _ := os.Getwd()
+	fmt.Printf("Merkle tree saved to:\n%s/%s %s\n", cwd, TreeFilePath, elapsed)
+}
+
 func deleteFilesInDir(path string) {
 	fileutil.RemoveDir(path)
 	fileutil.MakeDir(path)
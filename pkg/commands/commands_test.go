@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/api"
+	"gitlab.com/CaelRowley/merkle-tree-file-verification-client/pkg/merkletree"
+)
+
+func TestCompareWithServer(t *testing.T) {
+	// The server keeps files in upload order, the stored tree in hash order
+	var files []api.FileHash
+	var hashes [][]byte
+	var names []string
+	for i := 0; i < 11; i++ {
+		name := fmt.Sprintf("%d.txt", i)
+		hash := merkletree.Sum(merkletree.DefaultHasher, []byte("Hello "+name))
+		files = append(files, api.FileHash{Name: name, Hash: hash})
+		hashes = append(hashes, hash)
+		names = append(names, name)
+	}
+	hashes, names = sortByHash(hashes, names)
+	tree, err := merkletree.BuildTree(hashes, merkletree.WithLeafOrder(merkletree.LeafOrderSortedHash), merkletree.WithLeafNames(names))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	corrupted := append([]api.FileHash{}, files...)
+	corrupted[4].Hash = merkletree.Sum(merkletree.DefaultHasher, []byte("corrupt"))
+	changed := append([]api.FileHash{}, files[1:]...)
+	changed = append(changed, api.FileHash{Name: "new.txt", Hash: files[0].Hash})
+
+	tests := []struct {
+		name   string
+		server []api.FileHash
+		want   map[string]merkletree.DiffKind
+	}{
+		{name: "same", server: files, want: map[string]merkletree.DiffKind{}},
+		{name: "corrupted", server: corrupted, want: map[string]merkletree.DiffKind{"4.txt": merkletree.LeafChanged}},
+		{
+			name:   "removed and added",
+			server: changed,
+			want:   map[string]merkletree.DiffKind{"0.txt": merkletree.LeafRemoved, "new.txt": merkletree.LeafAdded},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(tt.server)
+			}))
+			defer server.Close()
+
+			_, diffs, err := compareWithServer(context.Background(), api.NewClient(server.URL), tree)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if len(diffs) != len(tt.want) {
+				t.Fatalf("got diffs %v, want %v", diffs, tt.want)
+			}
+			for _, diff := range diffs {
+				if kind, ok := tt.want[diff.Name]; !ok || kind != diff.Kind {
+					t.Errorf("got diff %v, want %v", diff, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -2,13 +2,43 @@ package fileutil
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 type File struct {
 	Name string
 	Data []byte
+	// Mode holds the permission bits, when known
+	Mode fs.FileMode `json:",omitempty"`
+}
+
+// FileSource gives random access to a list of files, so callers can read
+// only the files they are working on instead of holding every file in memory
+type FileSource interface {
+	Len() int
+	Read(start int, end int) ([]File, error)
+}
+
+// Files is a FileSource for files that are already in memory
+type Files []File
+
+func (f Files) Len() int {
+	return len(f)
+}
+
+func (f Files) Read(start int, end int) ([]File, error) {
+	return f[start:end], nil
+}
+
+// Dir is a FileSource that only lists the file names up front and reads the
+// contents from disk when asked for them
+type Dir struct {
+	Path  string
+	Names []string
 }
 
 func MakeDir(path string) {
@@ -69,42 +99,109 @@ func GetFile(path string) ([]byte, error) {
 	return data, nil
 }
 
-func GetFiles(path string, ch chan<- int) []File {
+func ListDir(path string) (*Dir, error) {
 	pageSize := 1024
 	dir, err := os.Open(path)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 	defer dir.Close()
 
-	var allDirs []os.DirEntry
+	var names []string
 	for {
-		dirs, err := dir.ReadDir(pageSize)
+		entries, err := dir.ReadDir(pageSize)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			fmt.Println(err)
+			return nil, err
 		}
-		if len(dirs) == 0 {
-			break
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				names = append(names, entry.Name())
+			}
 		}
-		allDirs = append(allDirs, dirs...)
 	}
 
-	var allFiles []File
-	for i, dir := range allDirs {
-		file, err := GetFile(path + "/" + dir.Name())
+	return &Dir{Path: path, Names: names}, nil
+}
+
+func (d *Dir) Len() int {
+	return len(d.Names)
+}
+
+func (d *Dir) Read(start int, end int) ([]File, error) {
+	files := make([]File, 0, end-start)
+	for _, name := range d.Names[start:end] {
+		data, err := GetFile(d.Path + "/" + name)
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
-		newFile := File{
-			Name: dir.Name(),
-			Data: file,
+		info, err := os.Stat(d.Path + "/" + name)
+		if err != nil {
+			return nil, err
 		}
-		allFiles = append(allFiles, newFile)
-		ch <- i
+		files = append(files, File{Name: name, Data: data, Mode: info.Mode().Perm()})
 	}
+	return files, nil
+}
+
+// HashFiles streams every file in the directory through hashFile across
+// workers goroutines, so at most one read buffer per worker is in memory.
+// The hashes are in the same order as d.Names.
+func (d *Dir) HashFiles(hashFile func(r io.Reader) ([]byte, error), workers int, ch chan<- int) ([][]byte, error) {
+	hashes := make([][]byte, len(d.Names))
+	jobs := make(chan int)
+	quit := make(chan struct{})
+
+	var firstErr error
+	var errOnce sync.Once
+	var count atomic.Int64
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fileHash, err := HashFile(d.Path+"/"+d.Names[i], hashFile)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(quit)
+					})
+					return
+				}
+				hashes[i] = fileHash
+				ch <- int(count.Add(1))
+			}
+		}()
+	}
+
+	func() {
+		defer close(jobs)
+		for i := range d.Names {
+			select {
+			case jobs <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return hashes, nil
+}
+
+func HashFile(path string, hashFile func(r io.Reader) ([]byte, error)) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-	return allFiles
+	return hashFile(file)
 }
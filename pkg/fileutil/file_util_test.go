@@ -0,0 +1,124 @@
+package fileutil
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDir(t *testing.T, n int) *Dir {
+	path := t.TempDir()
+	for i := 0; i < n; i++ {
+		err := os.WriteFile(filepath.Join(path, fmt.Sprintf("%02d.txt", i)), []byte(fmt.Sprintf("Hello %d", i)), 0644)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+	}
+
+	dir, err := ListDir(path)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if dir.Len() != n {
+		t.Fatalf("got %d files, want %d", dir.Len(), n)
+	}
+	return dir
+}
+
+func sha256File(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	return h.Sum(nil), err
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := testDir(t, 25)
+
+	for _, workers := range []int{0, 1, 4, 50} {
+		ch := make(chan int, dir.Len())
+		hashes, err := dir.HashFiles(sha256File, workers, ch)
+		if err != nil {
+			t.Fatalf("workers %d: returned unexpected error: %v", workers, err)
+		}
+
+		// The hashes are in the order of the names, however the work was split
+		for i, name := range dir.Names {
+			data, err := os.ReadFile(filepath.Join(dir.Path, name))
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			want := sha256.Sum256(data)
+			if string(hashes[i]) != string(want[:]) {
+				t.Errorf("workers %d: got hash %x for %s, want %x", workers, hashes[i], name, want)
+			}
+		}
+
+		close(ch)
+		var counts []int
+		for count := range ch {
+			counts = append(counts, count)
+		}
+		if len(counts) != dir.Len() {
+			t.Fatalf("workers %d: got %d progress updates, want %d", workers, len(counts), dir.Len())
+		}
+		seen := make(map[int]bool)
+		for _, count := range counts {
+			if count < 1 || count > dir.Len() || seen[count] {
+				t.Errorf("workers %d: got progress counts %v, want each of 1 to %d once", workers, counts, dir.Len())
+				break
+			}
+			seen[count] = true
+		}
+	}
+}
+
+func TestHashFilesError(t *testing.T) {
+	dir := testDir(t, 25)
+
+	// Files ending in 3 fail, and the first of them in the directory's order
+	// is the one a single worker stops at
+	var firstFailing string
+	var firstIndex int
+	for i, name := range dir.Names {
+		if strings.HasSuffix(name, "3.txt") {
+			firstIndex = i
+			data, _ := os.ReadFile(filepath.Join(dir.Path, name))
+			firstFailing = string(data) + ": failed"
+			break
+		}
+	}
+
+	failed := errors.New("failed")
+	for _, workers := range []int{1, 4} {
+		ch := make(chan int, dir.Len())
+		hashes, err := dir.HashFiles(func(r io.Reader) ([]byte, error) {
+			data, _ := io.ReadAll(r)
+			if strings.HasSuffix(string(data), "3") {
+				return nil, fmt.Errorf("%s: %w", data, failed)
+			}
+			return sha256File(strings.NewReader(string(data)))
+		}, workers, ch)
+
+		if hashes != nil || !errors.Is(err, failed) {
+			t.Errorf("workers %d: got hashes %v and error %v, want only the hashing error", workers, hashes, err)
+		}
+		if workers == 1 && err != nil && err.Error() != firstFailing {
+			t.Errorf("got error %v, want the error for the first file to fail", err)
+		}
+		if workers == 1 && len(ch) != firstIndex {
+			t.Errorf("got %d progress updates, want %d for the files before the first to fail", len(ch), firstIndex)
+		}
+	}
+
+	ch := make(chan int, dir.Len())
+	os.Remove(filepath.Join(dir.Path, dir.Names[7]))
+	_, err := dir.HashFiles(sha256File, 4, ch)
+	if !os.IsNotExist(err) {
+		t.Errorf("got error %v, want a not exist error for a missing file", err)
+	}
+}
@@ -0,0 +1,105 @@
+package merkletree
+
+import "errors"
+
+// Builder appends leaves one at a time and only keeps the right edge of the
+// tree: the roots of the complete subtrees, fewer than the arity per level.
+// It gives the same root as BuildTree over the same leaves.
+type Builder struct {
+	scheme   scheme
+	frontier [][][]byte
+	size     int
+	// err is set when the options are invalid and returned by Root
+	err error
+}
+
+func NewBuilder(opts ...Option) *Builder {
+	config := &MerkleTree{Hasher: DefaultHasher}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return &Builder{scheme: config.scheme(), err: config.validate()}
+}
+
+func (b *Builder) Append(hash []byte) {
+	if b.err != nil {
+		return
+	}
+	node := b.scheme.hashLeaf(hash)
+
+	// Carry completed subtrees up like adding one to a counter in base arity
+	level := 0
+	for ; level < len(b.frontier) && len(b.frontier[level]) == b.scheme.arity-1; level++ {
+		node = b.scheme.hashChildren(append(b.frontier[level], node))
+		b.frontier[level] = b.frontier[level][:0]
+	}
+	if level == len(b.frontier) {
+		b.frontier = append(b.frontier, make([][]byte, 0, b.scheme.arity))
+	}
+	b.frontier[level] = append(b.frontier[level], node)
+
+	b.size++
+}
+
+// Hasher is the hash function the builder was configured with, for hashing
+// leaves the same way
+func (b *Builder) Hasher() Hasher {
+	return b.scheme.hasher
+}
+
+func (b *Builder) Size() int {
+	return b.size
+}
+
+func (b *Builder) Root() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.size == 0 {
+		return nil, errors.New("cannot get the root of a Merkle tree without any leaves")
+	}
+
+	subtrees := completeSubtrees(b.size, b.scheme.arity)
+	for level := range subtrees {
+		for i := range subtrees[level] {
+			subtrees[level][i].hash = b.frontier[level][i]
+		}
+	}
+
+	root, _ := computeRoot(b.scheme, b.size, subtrees, noSiblings)
+	return root, nil
+}
+
+// Append adds a leaf to the end of the tree, only rehashing the nodes along
+// its right edge. On a tree with leaf names the new leaf has no name, use
+// AppendNamed to give it one.
+func (t *MerkleTree) Append(hash []byte) {
+	scheme := t.scheme()
+	t.leaves = append(t.leaves, hash)
+	if t.names != nil {
+		t.names = append(t.names, "")
+	}
+	t.levels[0] = append(t.levels[0], newNode(scheme.hashLeaf(hash), nil, nil))
+	t.LeafCount++
+
+	level := 0
+	for len(t.levels[level]) > 1 {
+		nodes := t.levels[level]
+		parentPosition := (len(nodes) - 1) / scheme.arity
+		parent := scheme.parent(nodes, parentPosition)
+
+		if level+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		if parentPosition < len(t.levels[level+1]) {
+			t.levels[level+1][parentPosition] = parent
+		} else {
+			t.levels[level+1] = append(t.levels[level+1], parent)
+		}
+		level++
+	}
+
+	t.Root = t.levels[level][0]
+	t.checkOrderAround(t.LeafCount - 1)
+}
@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "domain separated", opts: []Option{WithDomainSeparation()}},
+		{name: "sha3", opts: []Option{WithHasher(SHA3_256)}},
+		{name: "promote odd nodes", opts: []Option{WithOddNodeStrategy(OddNodePromote)}},
+		{name: "zero odd node siblings", opts: []Option{WithOddNodeStrategy(OddNodeZero)}},
+		{name: "arity 3", opts: []Option{WithArity(3), WithOddNodeStrategy(OddNodeZero)}},
+		{name: "arity 4", opts: []Option{WithArity(4), WithDomainSeparation()}},
+		{name: "arity 16", opts: []Option{WithArity(16), WithOddNodeStrategy(OddNodePromote)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewBuilder(tt.opts...)
+			if _, err := builder.Root(); err == nil {
+				t.Error("expected an error for empty builder")
+			}
+
+			var leafHashes [][]byte
+			for i := 0; i < 33; i++ {
+				hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+				leafHashes = append(leafHashes, hash[:])
+				builder.Append(hash[:])
+
+				tree, err := BuildTree(leafHashes, tt.opts...)
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				got, err := builder.Root()
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, tree.RootHash()) {
+					t.Errorf("size %d: got %x, want %x", builder.Size(), got, tree.RootHash())
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTreeAppend(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 20; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+		t.Run(string(strategy), func(t *testing.T) {
+			testMerkleTreeAppend(t, leafHashes, WithDomainSeparation(), WithOddNodeStrategy(strategy))
+		})
+		t.Run(string(strategy)+" arity 4", func(t *testing.T) {
+			testMerkleTreeAppend(t, leafHashes, WithArity(4), WithOddNodeStrategy(strategy))
+		})
+	}
+}
+
+func testMerkleTreeAppend(t *testing.T, leafHashes [][]byte, opts ...Option) {
+	tree, err := BuildTree(leafHashes[:1], opts...)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	for size := 2; size <= len(leafHashes); size++ {
+		tree.Append(leafHashes[size-1])
+
+		want, err := BuildTree(leafHashes[:size], opts...)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(tree.RootHash(), want.RootHash()) {
+			t.Errorf("size %d: got %x, want %x", size, tree.RootHash(), want.RootHash())
+		}
+		if tree.LeafCount != size {
+			t.Errorf("expected leaf count to be %d, got %d", size, tree.LeafCount)
+		}
+
+		for index := 0; index < size; index++ {
+			proof, err := tree.CreateProof(index)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if valid, _ := tree.VerifyProof(leafHashes[index], proof); !valid {
+				t.Errorf("size %d: expected true for leaf %d", size, index)
+			}
+		}
+	}
+}
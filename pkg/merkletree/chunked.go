@@ -0,0 +1,193 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkedFile is a file split into fixed-size chunks with a tree over the
+// chunk hashes. Its root is used as the file's leaf in the tree of files, so
+// one chunk can be verified without downloading the rest of the file.
+type ChunkedFile struct {
+	Size      int64
+	ChunkSize int
+	Chunks    *MerkleTree
+}
+
+// ChunkProof chains a proof from a chunk up to its file's root with a proof
+// from that file up to the root of the tree of files.
+type ChunkProof struct {
+	ChunkSize int
+	FileSize  int64
+	Chunk     MerkleProof
+	File      MerkleProof
+}
+
+// WithChunkSize records that each leaf is the root of a file's chunk tree
+// rather than the hash of the whole file.
+func WithChunkSize(n int) Option {
+	return func(t *MerkleTree) {
+		t.ChunkSize = n
+	}
+}
+
+func BuildChunkedFile(r io.Reader, chunkSize int, opts ...Option) (*ChunkedFile, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+
+	tree, err := newTree(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunkHashes [][]byte
+	size, err := hashChunks(r, chunkSize, tree.Hasher, func(hash []byte) {
+		chunkHashes = append(chunkHashes, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree.leaves = chunkHashes
+	tree.LeafCount = len(chunkHashes)
+	tree.buildLevels()
+
+	return &ChunkedFile{Size: size, ChunkSize: chunkSize, Chunks: tree}, nil
+}
+
+func (f *ChunkedFile) RootHash() []byte {
+	return f.Chunks.RootHash()
+}
+
+// FileHash hashes a file into a leaf: the hash of the whole file when
+// chunkSize is 0, otherwise the root of its chunk tree, which is streamed so
+// only one chunk is held in memory. A file that fits in one chunk gets the
+// same leaf either way unless the tree is domain separated.
+func FileHash(r io.Reader, chunkSize int, opts ...Option) ([]byte, error) {
+	builder := NewBuilder(opts...)
+	hasher := builder.scheme.hasher
+
+	if chunkSize <= 0 {
+		h := hasher.New()
+		_, err := io.Copy(h, r)
+		if err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	_, err := hashChunks(r, chunkSize, hasher, builder.Append)
+	if err != nil {
+		return nil, err
+	}
+	return builder.Root()
+}
+
+// FileHash hashes a file the same way the tree's leaves were made
+func (t *MerkleTree) FileHash(r io.Reader) ([]byte, error) {
+	return FileHash(r, t.ChunkSize, t.Options()...)
+}
+
+// hashChunks passes the hash of each chunk of r to fn and returns the number
+// of bytes read. An empty file is a single empty chunk.
+func hashChunks(r io.Reader, chunkSize int, hasher Hasher, fn func(hash []byte)) (int64, error) {
+	var size int64
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if n > 0 || size == 0 {
+			fn(Sum(hasher, chunk[:n]))
+		}
+		size += int64(n)
+		if err != nil {
+			return size, nil
+		}
+	}
+}
+
+// CreateChunkProof proves a chunk of the file at fileIndex, whose chunk tree
+// must be file.
+func (t *MerkleTree) CreateChunkProof(fileIndex int, file *ChunkedFile, chunkIndex int) (ChunkProof, error) {
+	if t.ChunkSize != file.ChunkSize {
+		return ChunkProof{}, fmt.Errorf("file has chunk size %d but the tree uses %d", file.ChunkSize, t.ChunkSize)
+	}
+	if !file.Chunks.scheme().equal(t.scheme()) {
+		return ChunkProof{}, errors.New("file chunks are hashed differently than the tree")
+	}
+
+	leaf, err := t.Leaf(fileIndex)
+	if err != nil {
+		return ChunkProof{}, err
+	}
+	if !bytes.Equal(leaf, file.RootHash()) {
+		return ChunkProof{}, fmt.Errorf("file does not match leaf %d", fileIndex)
+	}
+
+	chunkProof, err := file.Chunks.CreateProof(chunkIndex)
+	if err != nil {
+		return ChunkProof{}, err
+	}
+	fileProof, err := t.CreateProof(fileIndex)
+	if err != nil {
+		return ChunkProof{}, err
+	}
+
+	return ChunkProof{
+		ChunkSize: file.ChunkSize,
+		FileSize:  file.Size,
+		Chunk:     chunkProof,
+		File:      fileProof,
+	}, nil
+}
+
+// ChunkCount is how many chunks the proven file is split into
+func (p ChunkProof) ChunkCount() int {
+	if p.ChunkSize <= 0 || p.FileSize <= 0 {
+		return 1
+	}
+	return int((p.FileSize + int64(p.ChunkSize) - 1) / int64(p.ChunkSize))
+}
+
+func (t *MerkleTree) VerifyChunkProof(chunk []byte, proof ChunkProof) (bool, []byte) {
+	proofScheme, err := proof.File.scheme()
+	if err != nil || !proofScheme.equal(t.scheme()) || proof.ChunkSize != t.ChunkSize || proof.File.TreeSize != t.LeafCount {
+		return false, nil
+	}
+	return VerifyChunkProof(t.Root.Hash, chunk, proof)
+}
+
+// VerifyChunkProof also checks the chunk has the length its position in the
+// file implies, so a truncated chunk can't be accepted when resuming.
+func VerifyChunkProof(rootHash []byte, chunk []byte, proof ChunkProof) (bool, []byte) {
+	chunkScheme, err := proof.Chunk.scheme()
+	if err != nil {
+		return false, nil
+	}
+	fileScheme, err := proof.File.scheme()
+	if err != nil || !chunkScheme.equal(fileScheme) {
+		return false, nil
+	}
+
+	if proof.ChunkSize <= 0 || proof.FileSize < 0 || proof.Chunk.TreeSize != proof.ChunkCount() {
+		return false, nil
+	}
+	chunkLength := int64(proof.ChunkSize)
+	if proof.Chunk.LeafIndex == proof.ChunkCount()-1 {
+		chunkLength = proof.FileSize - int64(proof.Chunk.LeafIndex)*int64(proof.ChunkSize)
+	}
+	if int64(len(chunk)) != chunkLength {
+		return false, nil
+	}
+
+	_, fileHash := VerifyMerkleProof(nil, Sum(chunkScheme.hasher, chunk), proof.Chunk)
+	if fileHash == nil {
+		return false, nil
+	}
+	return VerifyMerkleProof(rootHash, fileHash, proof.File)
+}
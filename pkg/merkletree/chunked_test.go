@@ -0,0 +1,195 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testFileContents(sizes ...int) [][]byte {
+	var files [][]byte
+	for i, size := range sizes {
+		files = append(files, bytes.Repeat([]byte(fmt.Sprintf("file%d", i)), size)[:size])
+	}
+	return files
+}
+
+func TestFileHash(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "domain separated", opts: []Option{WithDomainSeparation()}},
+		{name: "sha3", opts: []Option{WithHasher(SHA3_256)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, data := range testFileContents(0, 1, 15, 16, 17, 100) {
+				got, err := FileHash(bytes.NewReader(data), 16, tt.opts...)
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+
+				file, err := BuildChunkedFile(bytes.NewReader(data), 16, tt.opts...)
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, file.RootHash()) {
+					t.Errorf("size %d: got %x, want %x", len(data), got, file.RootHash())
+				}
+				if file.Size != int64(len(data)) {
+					t.Errorf("got size %d, want %d", file.Size, len(data))
+				}
+
+				whole, err := FileHash(bytes.NewReader(data), 0, tt.opts...)
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				hasher := NewBuilder(tt.opts...).scheme.hasher
+				if !reflect.DeepEqual(whole, Sum(hasher, data)) {
+					t.Errorf("size %d: got %x, want the hash of the whole file", len(data), whole)
+				}
+			}
+		})
+	}
+}
+
+func TestSingleChunkMatchesWholeFile(t *testing.T) {
+	data := []byte("Hello 1")
+	got, err := FileHash(bytes.NewReader(data), 1024)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, Sum(DefaultHasher, data)) {
+		t.Errorf("got %x, want %x", got, Sum(DefaultHasher, data))
+	}
+}
+
+func TestChunkProof(t *testing.T) {
+	const chunkSize = 8
+	files := testFileContents(0, 8, 20, 33, 64)
+
+	var chunkedFiles []*ChunkedFile
+	var leafHashes [][]byte
+	for _, data := range files {
+		file, err := BuildChunkedFile(bytes.NewReader(data), chunkSize, WithDomainSeparation())
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		chunkedFiles = append(chunkedFiles, file)
+		leafHashes = append(leafHashes, file.RootHash())
+	}
+
+	tree, err := BuildTree(leafHashes, WithDomainSeparation(), WithChunkSize(chunkSize))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for fileIndex, data := range files {
+		fileHash, err := tree.FileHash(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(fileHash, leafHashes[fileIndex]) {
+			t.Errorf("file %d: got leaf %x, want %x", fileIndex, fileHash, leafHashes[fileIndex])
+		}
+
+		file := chunkedFiles[fileIndex]
+		for chunkIndex := 0; chunkIndex < file.Chunks.LeafCount; chunkIndex++ {
+			proof, err := tree.CreateChunkProof(fileIndex, file, chunkIndex)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if proof.ChunkCount() != file.Chunks.LeafCount {
+				t.Errorf("got %d chunks, want %d", proof.ChunkCount(), file.Chunks.LeafCount)
+			}
+
+			chunk := data[min(chunkIndex*chunkSize, len(data)):min((chunkIndex+1)*chunkSize, len(data))]
+			if valid, _ := tree.VerifyChunkProof(chunk, proof); !valid {
+				t.Errorf("file %d chunk %d: expected proof to be valid", fileIndex, chunkIndex)
+			}
+
+			tampered := append([]byte{}, chunk...)
+			if len(tampered) > 0 {
+				tampered[0] ^= 1
+				if valid, _ := tree.VerifyChunkProof(tampered, proof); valid {
+					t.Errorf("file %d chunk %d: expected tampered chunk to be invalid", fileIndex, chunkIndex)
+				}
+			}
+			if len(chunk) > 0 {
+				if valid, _ := tree.VerifyChunkProof(chunk[:len(chunk)-1], proof); valid {
+					t.Errorf("file %d chunk %d: expected truncated chunk to be invalid", fileIndex, chunkIndex)
+				}
+			}
+		}
+	}
+}
+
+func TestChunkProofInvalid(t *testing.T) {
+	const chunkSize = 8
+	data := testFileContents(20)[0]
+	file, err := BuildChunkedFile(bytes.NewReader(data), chunkSize)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	other := Sum(DefaultHasher, []byte("other"))
+	tree, err := BuildTree([][]byte{other, file.RootHash()}, WithChunkSize(chunkSize))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if _, err := tree.CreateChunkProof(0, file, 0); err == nil {
+		t.Error("expected an error for a file that doesn't match its leaf")
+	}
+	if _, err := tree.CreateChunkProof(1, file, 3); err == nil {
+		t.Error("expected an error for a chunk index out of range")
+	}
+
+	proof, err := tree.CreateChunkProof(1, file, 1)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	chunk := data[8:16]
+
+	wrongSize := proof
+	wrongSize.FileSize = 40
+	if valid, _ := tree.VerifyChunkProof(chunk, wrongSize); valid {
+		t.Error("expected proof with the wrong file size to be invalid")
+	}
+
+	wrongChunkSize := proof
+	wrongChunkSize.ChunkSize = 4
+	if valid, _ := VerifyChunkProof(tree.RootHash(), chunk, wrongChunkSize); valid {
+		t.Error("expected proof with the wrong chunk size to be invalid")
+	}
+
+	// Without a tree size the server's sibling flags would place the leaf
+	legacy := proof
+	legacy.File.TreeSize = 0
+	legacy.File.LeafIndex = 0
+	if valid, _ := tree.VerifyChunkProof(chunk, legacy); valid {
+		t.Error("expected file proof without a tree size to be invalid")
+	}
+	wrongTreeSize := proof
+	wrongTreeSize.File.TreeSize = 3
+	if valid, _ := tree.VerifyChunkProof(chunk, wrongTreeSize); valid {
+		t.Error("expected file proof with the wrong tree size to be invalid")
+	}
+
+	mixedScheme := proof
+	mixedScheme.Chunk.DomainSeparated = true
+	if valid, _ := VerifyChunkProof(tree.RootHash(), chunk, mixedScheme); valid {
+		t.Error("expected proof with mismatched schemes to be invalid")
+	}
+
+	wholeFiles, err := BuildTree([][]byte{other, file.RootHash()})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if valid, _ := wholeFiles.VerifyChunkProof(chunk, proof); valid {
+		t.Error("expected chunk proof to be rejected by a tree without chunks")
+	}
+}
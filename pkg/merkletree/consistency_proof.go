@@ -0,0 +1,151 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ConsistencyProof proves that a tree of NewSize leaves is an append-only
+// extension of an earlier tree of OldSize leaves, in the style of RFC 6962.
+//
+// Hashes starts with the roots of the complete subtrees that make up the old
+// tree, largest first, which are left out when the old tree is itself
+// complete since the old root is that subtree. The rest are the siblings
+// needed to hash those subtrees up to the new root, bottom up and left to
+// right.
+type ConsistencyProof struct {
+	Algorithm       string
+	DomainSeparated bool
+	OddNode         OddNodeStrategy `json:",omitempty"`
+	Arity           int             `json:",omitempty"`
+	OldSize         int
+	NewSize         int
+	Hashes          [][]byte
+}
+
+func (t *MerkleTree) CreateConsistencyProof(oldSize int) (ConsistencyProof, error) {
+	if oldSize < 1 || oldSize > t.LeafCount {
+		return ConsistencyProof{}, fmt.Errorf("old size %d out of range for tree of size %d", oldSize, t.LeafCount)
+	}
+
+	proof := ConsistencyProof{
+		Algorithm:       t.Hasher.Name(),
+		DomainSeparated: t.DomainSeparated,
+		OddNode:         t.OddNode,
+		Arity:           t.Arity,
+		OldSize:         oldSize,
+		NewSize:         t.LeafCount,
+	}
+	if oldSize == t.LeafCount {
+		return proof, nil
+	}
+
+	scheme := t.scheme()
+	subtrees := completeSubtrees(oldSize, scheme.arity)
+	for level := range subtrees {
+		for i, subtree := range subtrees[level] {
+			subtrees[level][i].hash = t.levels[level][subtree.position].Hash
+		}
+	}
+
+	if !isComplete(subtrees) {
+		for level := len(subtrees) - 1; level >= 0; level-- {
+			for _, subtree := range subtrees[level] {
+				proof.Hashes = append(proof.Hashes, subtree.hash)
+			}
+		}
+	}
+
+	computeRoot(scheme, t.LeafCount, subtrees, t.collectSiblings(&proof.Hashes))
+
+	return proof, nil
+}
+
+// VerifyConsistencyProof checks that the tree with root newRoot extends this
+// tree without changing any of its leaves.
+func (t *MerkleTree) VerifyConsistencyProof(newRoot []byte, proof ConsistencyProof) bool {
+	proofScheme, err := proof.scheme()
+	if err != nil || !proofScheme.equal(t.scheme()) {
+		return false
+	}
+	if proof.OldSize != t.LeafCount {
+		return false
+	}
+	return VerifyConsistencyProof(t.Root.Hash, newRoot, proof)
+}
+
+func VerifyConsistencyProof(oldRoot []byte, newRoot []byte, proof ConsistencyProof) bool {
+	scheme, err := proof.scheme()
+	if err != nil {
+		return false
+	}
+
+	if proof.OldSize < 1 || proof.OldSize > proof.NewSize {
+		return false
+	}
+	if proof.OldSize == proof.NewSize {
+		return len(proof.Hashes) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+
+	hashes := proof.Hashes
+	subtrees := completeSubtrees(proof.OldSize, scheme.arity)
+	if isComplete(subtrees) {
+		subtrees[len(subtrees)-1][0].hash = oldRoot
+	} else {
+		for level := len(subtrees) - 1; level >= 0; level-- {
+			for i := range subtrees[level] {
+				if len(hashes) == 0 {
+					return false
+				}
+				subtrees[level][i].hash = hashes[0]
+				hashes = hashes[1:]
+			}
+		}
+
+		// The old tree's subtrees are all it takes to rebuild the old root
+		computedOldRoot, ok := computeRoot(scheme, proof.OldSize, subtrees, noSiblings)
+		if !ok || !bytes.Equal(computedOldRoot, oldRoot) {
+			return false
+		}
+	}
+
+	computedNewRoot, ok := computeRoot(scheme, proof.NewSize, subtrees, consumeSiblings(&hashes))
+	if !ok || len(hashes) != 0 {
+		return false
+	}
+
+	return bytes.Equal(computedNewRoot, newRoot)
+}
+
+func (p ConsistencyProof) scheme() (scheme, error) {
+	return schemeFor(p.Algorithm, p.DomainSeparated, p.OddNode, p.Arity)
+}
+
+// completeSubtrees splits the first size leaves into complete subtrees, as
+// many at each level as that digit of size written in base arity. Subtrees
+// are indexed by level, fewer than the arity each.
+func completeSubtrees(size int, arity int) [][]indexedHash {
+	var widths []int
+	for width := 1; width <= size; width *= arity {
+		widths = append(widths, width)
+	}
+
+	subtrees := make([][]indexedHash, len(widths))
+	start := 0
+	for level := len(subtrees) - 1; level >= 0; level-- {
+		for start+widths[level] <= size {
+			subtrees[level] = append(subtrees[level], indexedHash{position: start / widths[level]})
+			start += widths[level]
+		}
+	}
+	return subtrees
+}
+
+// isComplete reports whether subtrees is a single complete subtree
+func isComplete(subtrees [][]indexedHash) bool {
+	count := 0
+	for _, level := range subtrees {
+		count += len(level)
+	}
+	return count == 1
+}
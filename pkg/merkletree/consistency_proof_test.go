@@ -0,0 +1,117 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func buildTestTree(t *testing.T, size int, opts ...Option) *MerkleTree {
+	t.Helper()
+
+	var leafHashes [][]byte
+	for i := 0; i < size; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes, opts...)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	return tree
+}
+
+func TestConsistencyProof(t *testing.T) {
+	for _, arity := range []int{2, 3, 4} {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+			t.Run(fmt.Sprintf("%d %s", arity, strategy), func(t *testing.T) {
+				testConsistencyProof(t, WithArity(arity), WithOddNodeStrategy(strategy))
+			})
+		}
+	}
+}
+
+func testConsistencyProof(t *testing.T, opts ...Option) {
+	for newSize := 1; newSize <= 17; newSize++ {
+		newTree := buildTestTree(t, newSize, opts...)
+		for oldSize := 1; oldSize <= newSize; oldSize++ {
+			oldTree := buildTestTree(t, oldSize, opts...)
+
+			proof, err := newTree.CreateConsistencyProof(oldSize)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if !oldTree.VerifyConsistencyProof(newTree.RootHash(), proof) {
+				t.Errorf("expected true for old size %d and new size %d", oldSize, newSize)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofRejectsChangedLeaves(t *testing.T) {
+	oldTree := buildTestTree(t, 6, WithDomainSeparation())
+
+	var leafHashes [][]byte
+	for i := 0; i < 11; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	changed := sha256.Sum256([]byte("changed"))
+	leafHashes[3] = changed[:]
+	newTree, err := BuildTree(leafHashes, WithDomainSeparation())
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, err := newTree.CreateConsistencyProof(6)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if oldTree.VerifyConsistencyProof(newTree.RootHash(), proof) {
+		t.Error("expected false for a tree with a changed leaf")
+	}
+}
+
+func TestVerifyConsistencyProofInvalid(t *testing.T) {
+	oldTree := buildTestTree(t, 5)
+	newTree := buildTestTree(t, 12)
+
+	proof, err := newTree.CreateConsistencyProof(5)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	tampered := proof
+	tampered.Hashes = append([][]byte{}, proof.Hashes...)
+	tampered.Hashes[len(tampered.Hashes)-1] = []byte("invalidHash")
+	if oldTree.VerifyConsistencyProof(newTree.RootHash(), tampered) {
+		t.Error("expected false for invalid hash")
+	}
+
+	truncated := proof
+	truncated.Hashes = proof.Hashes[:len(proof.Hashes)-1]
+	if oldTree.VerifyConsistencyProof(newTree.RootHash(), truncated) {
+		t.Error("expected false for missing hashes")
+	}
+
+	extended := proof
+	extended.Hashes = append(append([][]byte{}, proof.Hashes...), []byte("extraHash"))
+	if oldTree.VerifyConsistencyProof(newTree.RootHash(), extended) {
+		t.Error("expected false for extra hashes")
+	}
+
+	wrongSize := proof
+	wrongSize.OldSize = 4
+	if VerifyConsistencyProof(oldTree.RootHash(), newTree.RootHash(), wrongSize) {
+		t.Error("expected false for the wrong old size")
+	}
+
+	_, err = newTree.CreateConsistencyProof(13)
+	if err == nil {
+		t.Error("expected an error for old size larger than the tree")
+	}
+	_, err = newTree.CreateConsistencyProof(0)
+	if err == nil {
+		t.Error("expected an error for empty old tree")
+	}
+}
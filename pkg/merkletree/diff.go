@@ -0,0 +1,101 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+)
+
+type DiffKind string
+
+const (
+	// LeafChanged is a leaf in both trees with a different hash
+	LeafChanged DiffKind = "changed"
+	// LeafAdded is a leaf past the end of the first tree
+	LeafAdded DiffKind = "added"
+	// LeafRemoved is a leaf past the end of the second tree
+	LeafRemoved DiffKind = "removed"
+)
+
+// LeafDiff is a leaf that differs between two trees. Name comes from the
+// first tree with leaf names, and is empty when neither has a name for it.
+type LeafDiff struct {
+	Index int
+	Name  string
+	Kind  DiffKind
+}
+
+// Diff returns the leaves that differ between a and b in index order. It
+// walks both trees from the root and only descends into subtrees whose
+// hashes disagree, so trees that share most of their leaves are compared
+// without visiting most of their nodes.
+func Diff(a *MerkleTree, b *MerkleTree) ([]LeafDiff, error) {
+	if !a.scheme().equal(b.scheme()) {
+		return nil, errors.New("cannot compare Merkle trees that are hashed differently")
+	}
+
+	walker := diffWalker{a: a, b: b, arity: a.scheme().arity}
+	top := max(len(a.levels), len(b.levels)) - 1
+	width := 1
+	for i := 0; i < top; i++ {
+		width *= walker.arity
+	}
+	walker.walk(top, 0, width)
+
+	return walker.diffs, nil
+}
+
+type diffWalker struct {
+	a     *MerkleTree
+	b     *MerkleTree
+	arity int
+	diffs []LeafDiff
+}
+
+// walk compares the nodes at position in level of both trees, which cover
+// width leaves each when the trees are big enough
+func (w *diffWalker) walk(level int, position int, width int) {
+	start := position * width
+	if start >= w.a.LeafCount && start >= w.b.LeafCount {
+		return
+	}
+
+	if level == 0 {
+		switch {
+		case start >= w.a.LeafCount:
+			w.add(start, LeafAdded)
+		case start >= w.b.LeafCount:
+			w.add(start, LeafRemoved)
+		case !bytes.Equal(w.a.levels[0][start].Hash, w.b.levels[0][start].Hash):
+			w.add(start, LeafChanged)
+		}
+		return
+	}
+
+	// Nodes are only the same subtree when they cover the same leaves,
+	// which isn't the case on the right edge of trees of different sizes
+	aNode := nodeAt(w.a, level, position)
+	bNode := nodeAt(w.b, level, position)
+	sameLeaves := min(start+width, w.a.LeafCount) == min(start+width, w.b.LeafCount)
+	if aNode != nil && bNode != nil && sameLeaves && bytes.Equal(aNode.Hash, bNode.Hash) {
+		return
+	}
+
+	for i := 0; i < w.arity; i++ {
+		w.walk(level-1, position*w.arity+i, width/w.arity)
+	}
+}
+
+func (w *diffWalker) add(index int, kind DiffKind) {
+	name, _ := w.a.LeafName(index)
+	if name == "" {
+		name, _ = w.b.LeafName(index)
+	}
+	w.diffs = append(w.diffs, LeafDiff{Index: index, Name: name, Kind: kind})
+}
+
+func nodeAt(t *MerkleTree, level int, position int) *Node {
+	if level >= len(t.levels) || position >= len(t.levels[level]) {
+		return nil
+	}
+	return t.levels[level][position]
+}
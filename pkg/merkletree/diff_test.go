@@ -0,0 +1,95 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	changed := func(index int) LeafDiff {
+		return LeafDiff{Index: index, Name: fmt.Sprintf("%d.txt", index), Kind: LeafChanged}
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		other   int
+		changes []int
+		opts    []Option
+		want    []LeafDiff
+	}{
+		{name: "same leaves", size: 13, other: 13},
+		{name: "one change", size: 13, other: 13, changes: []int{6}, want: []LeafDiff{changed(6)}},
+		{name: "first and last", size: 13, other: 13, changes: []int{12, 0}, want: []LeafDiff{changed(0), changed(12)}},
+		{name: "single leaf", size: 1, other: 1, changes: []int{0}, want: []LeafDiff{changed(0)}},
+		{
+			name:  "leaves added",
+			size:  5,
+			other: 7,
+			// Only the first tree has names, and these leaves aren't in it
+			want: []LeafDiff{{Index: 5, Kind: LeafAdded}, {Index: 6, Kind: LeafAdded}},
+		},
+		{
+			name:    "leaves removed and changed",
+			size:    9,
+			other:   8,
+			changes: []int{3},
+			opts:    []Option{WithOddNodeStrategy(OddNodePromote)},
+			want:    []LeafDiff{changed(3), {Index: 8, Name: "8.txt", Kind: LeafRemoved}},
+		},
+		{name: "arity 4", size: 30, other: 30, changes: []int{17, 2}, opts: []Option{WithArity(4)}, want: []LeafDiff{changed(2), changed(17)}},
+		{
+			name:  "arity 16 different sizes",
+			size:  17,
+			other: 18,
+			opts:  []Option{WithArity(16), WithOddNodeStrategy(OddNodeZero)},
+			want:  []LeafDiff{{Index: 17, Kind: LeafAdded}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leafHashes, names := testLeaves(tt.size)
+			a, err := BuildTree(leafHashes, append(tt.opts, WithLeafNames(names))...)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			otherHashes, _ := testLeaves(tt.other)
+			for _, index := range tt.changes {
+				hash := sha256.Sum256([]byte(fmt.Sprintf("changed%d", index)))
+				otherHashes[index] = hash[:]
+			}
+			b, err := BuildTree(otherHashes, tt.opts...)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			got, err := Diff(a, b)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSchemeMismatch(t *testing.T) {
+	leafHashes, _ := testLeaves(4)
+	a, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	b, err := BuildTree(leafHashes, WithArity(4))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if _, err := Diff(a, b); err == nil {
+		t.Error("expected an error for trees with different arity")
+	}
+}
@@ -0,0 +1,98 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hasher is the hash function used for leaves, interior nodes and file
+// contents. Its name is recorded in proofs so a verifier can tell which
+// algorithm a proof was made with.
+type Hasher interface {
+	Name() string
+	New() hash.Hash
+}
+
+type hasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+var (
+	SHA256     Hasher = NewHasher("sha256", sha256.New)
+	SHA512_256 Hasher = NewHasher("sha512-256", sha512.New512_256)
+	SHA3_256   Hasher = NewHasher("sha3-256", sha3.New256)
+	BLAKE2b256 Hasher = NewHasher("blake2b-256", newBlake2b256)
+)
+
+var DefaultHasher = SHA256
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]Hasher{
+		SHA256.Name():     SHA256,
+		SHA512_256.Name(): SHA512_256,
+		SHA3_256.Name():   SHA3_256,
+		BLAKE2b256.Name(): BLAKE2b256,
+	}
+)
+
+func NewHasher(name string, newHash func() hash.Hash) Hasher {
+	return hasher{
+		name:    name,
+		newHash: newHash,
+	}
+}
+
+func (h hasher) Name() string {
+	return h.name
+}
+
+func (h hasher) New() hash.Hash {
+	return h.newHash()
+}
+
+// RegisterHasher makes a custom hasher available to HasherByName, so proofs
+// made with it can be verified.
+func RegisterHasher(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	hashers[h.Name()] = h
+}
+
+// HasherByName looks up a registered hasher. An empty name is the default
+// hasher, which is what proofs from before algorithms were recorded use.
+func HasherByName(name string) (Hasher, error) {
+	if name == "" {
+		return DefaultHasher, nil
+	}
+
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	h, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm: %s", name)
+	}
+	return h, nil
+}
+
+func Sum(h Hasher, data []byte) []byte {
+	digest := h.New()
+	digest.Write(data)
+	return digest.Sum(nil)
+}
+
+func newBlake2b256() hash.Hash {
+	// Only fails for keys longer than 64 bytes
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
@@ -0,0 +1,89 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// LeafEncoding decides what a file's leaf commits to
+type LeafEncoding string
+
+const (
+	// LeafEncodingContent makes the leaf the hash of the file's content, so
+	// renaming files or swapping their names doesn't change the root
+	LeafEncodingContent LeafEncoding = "content"
+	// LeafEncodingMetadata makes the leaf commit to the file's path, size and
+	// mode as well as its content
+	LeafEncodingMetadata LeafEncoding = "metadata"
+)
+
+// FileMetadata is what a leaf commits to besides the content hash when the
+// tree uses LeafEncodingMetadata. Path is relative to the directory the tree
+// was built from, with forward slashes.
+type FileMetadata struct {
+	Path string
+	Size int64
+	Mode fs.FileMode
+}
+
+func WithLeafEncoding(encoding LeafEncoding) Option {
+	return func(t *MerkleTree) {
+		t.LeafEncoding = encoding
+	}
+}
+
+// defaultLeafEncoding treats a missing encoding as content only, which is
+// what trees from before the encoding was recorded used
+func defaultLeafEncoding(encoding LeafEncoding) LeafEncoding {
+	if encoding == "" {
+		return LeafEncodingContent
+	}
+	return encoding
+}
+
+func validateLeafEncoding(encoding LeafEncoding) error {
+	switch encoding {
+	case "", LeafEncodingContent, LeafEncodingMetadata:
+		return nil
+	}
+	return fmt.Errorf("unknown leaf encoding: %s", encoding)
+}
+
+// MetadataLeaf hashes a file's metadata and content hash into a leaf:
+//
+//	path length   uvarint
+//	path
+//	size          8 bytes big endian
+//	mode          4 bytes big endian, only the permission bits
+//	content hash
+//
+// The path is length prefixed so no two paths can encode the same bytes.
+func MetadataLeaf(hasher Hasher, metadata FileMetadata, contentHash []byte) []byte {
+	leaf := hasher.New()
+	leaf.Write(binary.AppendUvarint(nil, uint64(len(metadata.Path))))
+	leaf.Write([]byte(metadata.Path))
+	leaf.Write(binary.BigEndian.AppendUint64(nil, uint64(metadata.Size)))
+	leaf.Write(binary.BigEndian.AppendUint32(nil, uint32(metadata.Mode.Perm())))
+	leaf.Write(contentHash)
+	return leaf.Sum(nil)
+}
+
+// EncodeLeaf turns a file's hash, as made by FileHash, into its leaf with the
+// tree's leaf encoding
+func (t *MerkleTree) EncodeLeaf(metadata FileMetadata, fileHash []byte) []byte {
+	if t.LeafEncoding != LeafEncodingMetadata {
+		return fileHash
+	}
+	return MetadataLeaf(t.Hasher, metadata, fileHash)
+}
+
+// FileLeaf hashes a file into a leaf the same way the tree's leaves were made
+func (t *MerkleTree) FileLeaf(r io.Reader, metadata FileMetadata) ([]byte, error) {
+	fileHash, err := t.FileHash(r)
+	if err != nil {
+		return nil, err
+	}
+	return t.EncodeLeaf(metadata, fileHash), nil
+}
@@ -0,0 +1,109 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataLeaf(t *testing.T) {
+	content := sha256.Sum256([]byte("Hello 1"))
+	metadata := FileMetadata{Path: "dir/1.txt", Size: 7, Mode: 0644}
+	leaf := MetadataLeaf(SHA256, metadata, content[:])
+
+	other := sha256.Sum256([]byte("Hello 2"))
+	tests := []struct {
+		name     string
+		metadata FileMetadata
+		content  []byte
+	}{
+		{name: "path", metadata: FileMetadata{Path: "dir/2.txt", Size: 7, Mode: 0644}, content: content[:]},
+		{name: "size", metadata: FileMetadata{Path: "dir/1.txt", Size: 8, Mode: 0644}, content: content[:]},
+		{name: "mode", metadata: FileMetadata{Path: "dir/1.txt", Size: 7, Mode: 0755}, content: content[:]},
+		{name: "content", metadata: metadata, content: other[:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if bytes.Equal(MetadataLeaf(SHA256, tt.metadata, tt.content), leaf) {
+				t.Errorf("expected a different %s to change the leaf", tt.name)
+			}
+		})
+	}
+
+	// Only the permission bits are committed to
+	withType := metadata
+	withType.Mode |= fs.ModeDir
+	if !bytes.Equal(MetadataLeaf(SHA256, withType, content[:]), leaf) {
+		t.Error("expected mode type bits to be ignored")
+	}
+}
+
+func TestLeafEncodingDetectsSwappedNames(t *testing.T) {
+	contents := [][]byte{[]byte("Hello 0"), []byte("Hello 1"), []byte("Hello 1")}
+	names := []string{"0.txt", "1.txt", "2.txt"}
+
+	buildTree := func(names []string, opts ...Option) (*MerkleTree, [][]byte) {
+		config, err := newTree(opts...)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+
+		var leafHashes [][]byte
+		for i, content := range contents {
+			leaf, err := config.FileLeaf(bytes.NewReader(content), FileMetadata{Path: names[i], Size: int64(len(content)), Mode: 0644})
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			leafHashes = append(leafHashes, leaf)
+		}
+		tree, err := BuildTree(leafHashes, opts...)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		return tree, leafHashes
+	}
+
+	swapped := []string{"1.txt", "0.txt", "2.txt"}
+
+	contentTree, contentLeaves := buildTree(names)
+	if !reflect.DeepEqual(contentLeaves[1], contentLeaves[2]) {
+		t.Error("expected files with the same content to have the same leaf")
+	}
+	swappedContentTree, _ := buildTree(swapped)
+	if !reflect.DeepEqual(contentTree.RootHash(), swappedContentTree.RootHash()) {
+		t.Error("expected content leaves to ignore file names")
+	}
+
+	opts := []Option{WithLeafEncoding(LeafEncodingMetadata)}
+	tree, leafHashes := buildTree(names, opts...)
+	if reflect.DeepEqual(leafHashes[1], leafHashes[2]) {
+		t.Error("expected files with the same content but different names to have different leaves")
+	}
+	swappedTree, _ := buildTree(swapped, opts...)
+	if reflect.DeepEqual(tree.RootHash(), swappedTree.RootHash()) {
+		t.Error("expected swapping file names to change the root")
+	}
+
+	// File 0 served as 1.txt is rejected even though its content is right
+	proof, err := tree.CreateProof(0)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	served, err := tree.FileLeaf(bytes.NewReader(contents[0]), FileMetadata{Path: "1.txt", Size: 7, Mode: 0644})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if valid, _ := tree.VerifyProof(served, proof); valid {
+		t.Error("expected a file served under the wrong name to be invalid")
+	}
+	if valid, _ := tree.VerifyProof(leafHashes[0], proof); !valid {
+		t.Error("expected the file under its own name to be valid")
+	}
+
+	if _, err := BuildTree(leafHashes, WithLeafEncoding("path")); err == nil {
+		t.Error("expected an error for unknown leaf encoding")
+	}
+}
@@ -2,120 +2,407 @@ package merkletree
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-var Root *Node
+// RFC 6962 style prefixes so that a leaf can never be mistaken for an
+// interior node when a tree is built with domain separation.
+const (
+	leafPrefix byte = 0x00
+	nodePrefix byte = 0x01
+)
+
+// LeafOrder records how the leaves were ordered before the tree was built,
+// which BuildTree itself doesn't change.
+type LeafOrder string
+
+const (
+	LeafOrderInsertion  LeafOrder = "insertion"
+	LeafOrderSortedHash LeafOrder = "sorted-hash"
+)
+
+// OddNodeStrategy decides what happens to the last node of a level with an
+// odd number of nodes. Duplicating it means [a, b, c] and [a, b, c, c] have
+// the same root (CVE-2012-2459), which the other strategies avoid.
+type OddNodeStrategy string
+
+const (
+	// OddNodeDuplicate hashes the node with itself
+	OddNodeDuplicate OddNodeStrategy = "duplicate"
+	// OddNodePromote moves the node up to the next level unchanged
+	OddNodePromote OddNodeStrategy = "promote"
+	// OddNodeZero hashes the node with a sibling of all zero bytes
+	OddNodeZero OddNodeStrategy = "zero"
+)
+
+// MaxArity is the most children a node can have. Trees are binary unless
+// built with WithArity.
+const MaxArity = 256
 
 type MerkleTree struct {
-	ID   uuid.UUID
-	Root *Node
+	ID              uuid.UUID
+	Root            *Node
+	LeafCount       int
+	Hasher          Hasher
+	DomainSeparated bool
+	LeafOrder       LeafOrder
+	OddNode         OddNodeStrategy
+	Arity           int
+	ChunkSize       int
+	LeafEncoding    LeafEncoding
+	CreatedAt       time.Time
+
+	// workers is how many goroutines hash each level, set by WithWorkers
+	workers int
+	// leaves holds the hashes the tree was built from, before leaf hashing
+	leaves [][]byte
+	// names holds the file name of each leaf, if set by WithLeafNames
+	names     []string
+	nameIndex map[string]int
+	// levels[0] holds the leaf nodes and the last level holds only the root.
+	// Levels are stored without padding the last group of siblings.
+	levels [][]*Node
 }
 
+type Option func(*MerkleTree)
+
 type Node struct {
 	Hash  []byte
 	Left  *Node
 	Right *Node
+	// Children holds the nodes below a node of a tree that isn't binary, in
+	// place of Left and Right
+	Children []*Node
 }
 
-type MerkleProof []ProofSibling
+type MerkleProof struct {
+	Algorithm       string
+	DomainSeparated bool
+	OddNode         OddNodeStrategy `json:",omitempty"`
+	Arity           int             `json:",omitempty"`
+	LeafIndex       int
+	TreeSize        int
+	Siblings        []ProofSibling
+}
 
 type ProofSibling struct {
 	Hash   []byte
 	IsLeft bool
 }
 
-func BuildTree(hashes [][]byte) {
-	var currentLevel []*Node
+func WithHasher(hasher Hasher) Option {
+	return func(t *MerkleTree) {
+		t.Hasher = hasher
+	}
+}
+
+func WithDomainSeparation() Option {
+	return func(t *MerkleTree) {
+		t.DomainSeparated = true
+	}
+}
+
+// WithWorkers hashes each level across n goroutines. The root is the same as
+// building on one goroutine.
+func WithWorkers(n int) Option {
+	return func(t *MerkleTree) {
+		t.workers = n
+	}
+}
+
+func WithOddNodeStrategy(strategy OddNodeStrategy) Option {
+	return func(t *MerkleTree) {
+		t.OddNode = strategy
+	}
+}
+
+// WithArity gives every node up to n children instead of two, so a tree over
+// many files is shallower at the cost of larger proofs.
+func WithArity(n int) Option {
+	return func(t *MerkleTree) {
+		t.Arity = n
+	}
+}
+
+func WithLeafOrder(order LeafOrder) Option {
+	return func(t *MerkleTree) {
+		t.LeafOrder = order
+	}
+}
+
+func BuildTree(hashes [][]byte, opts ...Option) (*MerkleTree, error) {
+	if len(hashes) == 0 {
+		return nil, errors.New("cannot build a Merkle tree without any leaves")
+	}
+
+	tree, err := newTree(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.leaves = append([][]byte{}, hashes...)
+	tree.LeafCount = len(hashes)
+	err = tree.indexNames()
+	if err != nil {
+		return nil, err
+	}
+	tree.buildLevels()
+
+	return tree, nil
+}
+
+func newTree(opts ...Option) (*MerkleTree, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &MerkleTree{
+		ID:           id,
+		Hasher:       DefaultHasher,
+		OddNode:      OddNodeDuplicate,
+		Arity:        2,
+		LeafOrder:    LeafOrderInsertion,
+		LeafEncoding: LeafEncodingContent,
+		CreatedAt:    time.Now().UTC(),
+	}
+	for _, opt := range opts {
+		opt(tree)
+	}
+
+	err = tree.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
 
-	for _, hash := range hashes {
-		currentLevel = append(currentLevel, newNode(hash, nil, nil))
+// validate checks the options that decide how leaves and nodes are hashed
+func (t *MerkleTree) validate() error {
+	err := validateOddNode(t.OddNode)
+	if err != nil {
+		return err
+	}
+	err = validateLeafEncoding(t.LeafEncoding)
+	if err != nil {
+		return err
 	}
+	return validateArity(t.Arity)
+}
+
+// buildLevels hashes the leaves and every level above them up to the root
+func (t *MerkleTree) buildLevels() {
+	scheme := t.scheme()
+	currentLevel := make([]*Node, len(t.leaves))
+
+	parallelFor(len(t.leaves), t.workers, func(start int, end int) {
+		for i := start; i < end; i++ {
+			leafHash := scheme.hashLeaf(t.leaves[i])
+			currentLevel[i] = newNode(leafHash, nil, nil)
+		}
+	})
+
+	t.levels = [][]*Node{currentLevel}
 
 	// If len(currentLevel) is 1 we are at the root
 	for len(currentLevel) > 1 {
-		var nextLevel []*Node
+		nextLevel := make([]*Node, scheme.parentCount(len(currentLevel)))
 
-		// Is a binary tree so each node must have two children
-		if len(currentLevel)%2 != 0 {
-			currentLevel = append(currentLevel, currentLevel[len(currentLevel)-1])
-		}
+		parallelFor(len(nextLevel), t.workers, func(start int, end int) {
+			for i := start; i < end; i++ {
+				nextLevel[i] = scheme.parent(currentLevel, i)
+			}
+		})
+
+		t.levels = append(t.levels, nextLevel)
+		currentLevel = nextLevel
+	}
 
-		for i := 0; i < len(currentLevel); i += 2 {
-			left := currentLevel[i]
-			right := currentLevel[i+1]
-			hash := hashPair(left.Hash, right.Hash)
+	t.Root = currentLevel[0]
+}
 
-			newNode := newNode(hash, left, right)
-			nextLevel = append(nextLevel, newNode)
+// Below this many nodes a level is cheaper to hash on one goroutine
+const minParallelNodes = 1024
+
+// parallelFor splits [0, n) into one contiguous range per worker
+func parallelFor(n int, workers int, fn func(start int, end int)) {
+	if workers <= 1 || n < minParallelNodes {
+		fn(0, n)
+		return
+	}
+
+	chunkSize := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunkSize {
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, min(start+chunkSize, n))
+	}
+	wg.Wait()
+}
+
+func (t *MerkleTree) RootHash() []byte {
+	return t.Root.Hash
+}
+
+func (t *MerkleTree) Leaf(index int) ([]byte, error) {
+	if index < 0 || index >= t.LeafCount {
+		return nil, fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+	}
+	return t.leaves[index], nil
+}
+
+// CreateProof walks up from the leaf at index, so duplicate files with the
+// same hash still get a proof for their own position.
+func (t *MerkleTree) CreateProof(index int) (MerkleProof, error) {
+	if index < 0 || index >= t.LeafCount {
+		return MerkleProof{}, fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+	}
+
+	proof := MerkleProof{
+		Algorithm:       t.Hasher.Name(),
+		DomainSeparated: t.DomainSeparated,
+		OddNode:         t.OddNode,
+		Arity:           t.Arity,
+		LeafIndex:       index,
+		TreeSize:        t.LeafCount,
+	}
+
+	// Each level holds every other child of the parent, left to right,
+	// followed by any padding the odd node strategy fills a short group with
+	scheme := t.scheme()
+	position := index
+	for _, level := range t.levels[:len(t.levels)-1] {
+		children := scheme.children(level, position/scheme.arity)
+		hashes := make([][]byte, len(children))
+		for i, child := range children {
+			hashes[i] = child.Hash
 		}
 
-		currentLevel = nextLevel
+		start := position - position%scheme.arity
+		for i, hash := range hashes {
+			if start+i != position {
+				proof.Siblings = append(proof.Siblings, ProofSibling{Hash: hash, IsLeft: start+i < position})
+			}
+		}
+		for _, hash := range scheme.padding(hashes) {
+			proof.Siblings = append(proof.Siblings, ProofSibling{Hash: hash})
+		}
+		position /= scheme.arity
 	}
 
-	Root = currentLevel[0]
+	return proof, nil
 }
 
-func CreateMerkleProof(root *Node, hash []byte) (MerkleProof, error) {
-	var proof MerkleProof
+// VerifyProof rejects proofs made with a different hashing scheme than the
+// tree, rather than trusting the scheme recorded in the proof. The proof must
+// record the tree's size, so sibling positions come from the leaf index and
+// never from the IsLeft flags.
+func (t *MerkleTree) VerifyProof(hash []byte, proof MerkleProof) (bool, []byte) {
+	proofScheme, err := proof.scheme()
+	if err != nil || !proofScheme.equal(t.scheme()) || proof.TreeSize != t.LeafCount {
+		return false, nil
+	}
+	return VerifyMerkleProof(t.Root.Hash, hash, proof)
+}
 
-	var findHash func(node *Node) bool
-	findHash = func(node *Node) bool {
-		if node == nil {
-			return false
+// VerifyMerkleProof hashes with the algorithm recorded in the proof, so the
+// caller must compute hash with that same algorithm. Sibling positions come
+// from the leaf index and tree size; the IsLeft flags are only used for
+// binary proofs that don't record a tree size.
+func VerifyMerkleProof(rootHash []byte, hash []byte, proof MerkleProof) (bool, []byte) {
+	scheme, err := proof.scheme()
+	if err != nil {
+		return false, nil
+	}
+
+	if proof.TreeSize == 0 {
+		if scheme.arity != 2 {
+			return false, nil
 		}
+		return verifyLegacyProof(rootHash, scheme.hashLeaf(hash), proof.Siblings, scheme)
+	}
 
-		if node.Left == nil && node.Right == nil {
-			return bytes.Equal(node.Hash, hash)
+	if proof.LeafIndex < 0 || proof.LeafIndex >= proof.TreeSize {
+		return false, nil
+	}
+
+	currentHash := scheme.hashLeaf(hash)
+	position := proof.LeafIndex
+	levelSize := proof.TreeSize
+	siblings := proof.Siblings
+	for levelSize > 1 {
+		start := position - position%scheme.arity
+		count := min(scheme.arity, levelSize-start)
+		if len(siblings) < count-1 {
+			return false, nil
 		}
 
-		leftContainsHash := findHash(node.Left)
-		if leftContainsHash {
-			newProof := ProofSibling{
-				Hash:   node.Right.Hash,
-				IsLeft: false,
+		children := make([][]byte, 0, scheme.arity)
+		for i := start; i < start+count; i++ {
+			if i == position {
+				children = append(children, currentHash)
+			} else {
+				children = append(children, siblings[0].Hash)
+				siblings = siblings[1:]
 			}
-			proof = append(proof, newProof)
-			return true
 		}
 
-		rightContainsHash := findHash(node.Right)
-		if rightContainsHash {
-			newProof := ProofSibling{
-				Hash:   node.Left.Hash,
-				IsLeft: true,
+		// A short group must be padded the way its strategy pads it
+		for _, padding := range scheme.padding(children) {
+			if len(siblings) == 0 || !bytes.Equal(siblings[0].Hash, padding) {
+				return false, nil
 			}
-			proof = append(proof, newProof)
-			return true
+			siblings = siblings[1:]
 		}
+		currentHash = scheme.hashChildren(children)
 
-		return false
+		position /= scheme.arity
+		levelSize = scheme.parentCount(levelSize)
 	}
 
-	hashFound := findHash(root)
-	if !hashFound {
-		return nil, fmt.Errorf("hash not found in the Merkle tree")
+	if len(siblings) != 0 {
+		return false, nil
 	}
 
-	return proof, nil
+	return bytes.Equal(currentHash, rootHash), currentHash
 }
 
-func VerifyMerkleProof(rootHash []byte, hash []byte, proof MerkleProof) (bool, []byte) {
-	currentHash := hash
-	for _, sibling := range proof {
+func verifyLegacyProof(rootHash []byte, leafHash []byte, siblings []ProofSibling, scheme scheme) (bool, []byte) {
+	currentHash := leafHash
+	for _, sibling := range siblings {
 		if sibling.IsLeft {
-			currentHash = hashPair(sibling.Hash, currentHash)
+			currentHash = scheme.hashPair(sibling.Hash, currentHash)
 		} else {
-			currentHash = hashPair(currentHash, sibling.Hash)
+			currentHash = scheme.hashPair(currentHash, sibling.Hash)
 		}
 	}
 
 	return bytes.Equal(currentHash, rootHash), currentHash
 }
 
+// UnmarshalJSON also accepts the bare array of siblings the server sends,
+// which is always a SHA-256 proof without domain separation or leaf index.
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		*p = MerkleProof{}
+		return json.Unmarshal(trimmed, &p.Siblings)
+	}
+
+	type merkleProof MerkleProof
+	return json.Unmarshal(trimmed, (*merkleProof)(p))
+}
+
 func newNode(hash []byte, left *Node, right *Node) *Node {
 	return &Node{
 		Hash:  hash,
@@ -124,8 +411,203 @@ func newNode(hash []byte, left *Node, right *Node) *Node {
 	}
 }
 
-func hashPair(left []byte, right []byte) []byte {
-	pair := append(left, right...)
-	hash := sha256.Sum256(pair)
-	return hash[:]
+// scheme holds everything that decides how node hashes are computed, so a
+// tree and a proof can be checked for agreement.
+type scheme struct {
+	hasher          Hasher
+	domainSeparated bool
+	oddNode         OddNodeStrategy
+	arity           int
+}
+
+func (t *MerkleTree) scheme() scheme {
+	return scheme{
+		hasher:          t.Hasher,
+		domainSeparated: t.DomainSeparated,
+		oddNode:         defaultOddNode(t.OddNode),
+		arity:           defaultArity(t.Arity),
+	}
+}
+
+// Options returns the options that build a tree hashed the same way, for
+// building another tree that can be compared with this one
+func (t *MerkleTree) Options() []Option {
+	opts := []Option{
+		WithHasher(t.Hasher),
+		WithOddNodeStrategy(t.OddNode),
+		WithArity(t.Arity),
+		WithLeafEncoding(t.LeafEncoding),
+	}
+	if t.DomainSeparated {
+		opts = append(opts, WithDomainSeparation())
+	}
+	return opts
+}
+
+func (p MerkleProof) scheme() (scheme, error) {
+	return schemeFor(p.Algorithm, p.DomainSeparated, p.OddNode, p.Arity)
+}
+
+func schemeFor(algorithm string, domainSeparated bool, oddNode OddNodeStrategy, arity int) (scheme, error) {
+	hasher, err := HasherByName(algorithm)
+	if err != nil {
+		return scheme{}, err
+	}
+	err = validateOddNode(oddNode)
+	if err != nil {
+		return scheme{}, err
+	}
+	err = validateArity(arity)
+	if err != nil {
+		return scheme{}, err
+	}
+
+	return scheme{
+		hasher:          hasher,
+		domainSeparated: domainSeparated,
+		oddNode:         defaultOddNode(oddNode),
+		arity:           defaultArity(arity),
+	}, nil
+}
+
+// defaultOddNode treats a missing strategy as duplicate, which is what trees
+// and proofs from before the strategy was recorded used
+func defaultOddNode(strategy OddNodeStrategy) OddNodeStrategy {
+	if strategy == "" {
+		return OddNodeDuplicate
+	}
+	return strategy
+}
+
+func validateOddNode(strategy OddNodeStrategy) error {
+	switch strategy {
+	case "", OddNodeDuplicate, OddNodePromote, OddNodeZero:
+		return nil
+	}
+	return fmt.Errorf("unknown odd node strategy: %s", strategy)
+}
+
+// defaultArity treats a missing arity as binary, like trees and proofs from
+// before the arity was recorded
+func defaultArity(arity int) int {
+	if arity == 0 {
+		return 2
+	}
+	return arity
+}
+
+func validateArity(arity int) error {
+	if arity != 0 && (arity < 2 || arity > MaxArity) {
+		return fmt.Errorf("arity must be between 2 and %d, got %d", MaxArity, arity)
+	}
+	return nil
+}
+
+func (s scheme) equal(other scheme) bool {
+	return s.hasher.Name() == other.hasher.Name() &&
+		s.domainSeparated == other.domainSeparated &&
+		s.oddNode == other.oddNode &&
+		s.arity == other.arity
+}
+
+func (s scheme) hashLeaf(hash []byte) []byte {
+	if !s.domainSeparated {
+		return hash
+	}
+
+	leaf := s.hasher.New()
+	leaf.Write([]byte{leafPrefix})
+	leaf.Write(hash)
+	return leaf.Sum(nil)
+}
+
+// hashChildren hashes a group of up to arity children into their parent. A
+// group of one is promoted unchanged by OddNodePromote, and a short group is
+// otherwise padded up to the arity.
+func (s scheme) hashChildren(children [][]byte) []byte {
+	if len(children) == 1 && s.oddNode == OddNodePromote {
+		return children[0]
+	}
+
+	parent := s.hasher.New()
+	if s.domainSeparated {
+		parent.Write([]byte{nodePrefix})
+	}
+	for _, child := range children {
+		parent.Write(child)
+	}
+	for _, padding := range s.padding(children) {
+		parent.Write(padding)
+	}
+	return parent.Sum(nil)
+}
+
+// padding returns the hashes that fill a short group of children up to the
+// arity: copies of the last child, all zero hashes, or none when promoting
+func (s scheme) padding(children [][]byte) [][]byte {
+	if len(children) >= s.arity || s.oddNode == OddNodePromote {
+		return nil
+	}
+
+	fill := children[len(children)-1]
+	if s.oddNode == OddNodeZero {
+		fill = s.zeroHash()
+	}
+	padding := make([][]byte, s.arity-len(children))
+	for i := range padding {
+		padding[i] = fill
+	}
+	return padding
+}
+
+func (s scheme) zeroHash() []byte {
+	return make([]byte, s.hasher.New().Size())
+}
+
+// parentCount is the size of the level above one of levelSize nodes
+func (s scheme) parentCount(levelSize int) int {
+	return (levelSize + s.arity - 1) / s.arity
+}
+
+// children returns the nodes below the parent at position, which are fewer
+// than the arity for the last parent of a level that doesn't divide evenly
+func (s scheme) children(level []*Node, position int) []*Node {
+	start := position * s.arity
+	return level[start:min(start+s.arity, len(level))]
+}
+
+func (s scheme) parent(level []*Node, position int) *Node {
+	children := s.children(level, position)
+	hashes := make([][]byte, len(children))
+	for i, child := range children {
+		hashes[i] = child.Hash
+	}
+	return s.linkParent(s.hashChildren(hashes), children)
+}
+
+// linkParent makes the node above children. In a binary tree the last node
+// of an odd level is only its parent's right child as well when duplicated.
+func (s scheme) linkParent(hash []byte, children []*Node) *Node {
+	if s.arity != 2 {
+		return &Node{Hash: hash, Children: append([]*Node{}, children...)}
+	}
+
+	left := children[0]
+	var right *Node
+	if len(children) == 2 {
+		right = children[1]
+	} else if s.oddNode == OddNodeDuplicate {
+		right = left
+	}
+	return newNode(hash, left, right)
+}
+
+func (s scheme) hashPair(left []byte, right []byte) []byte {
+	pair := s.hasher.New()
+	if s.domainSeparated {
+		pair.Write([]byte{nodePrefix})
+	}
+	pair.Write(left)
+	pair.Write(right)
+	return pair.Sum(nil)
 }
@@ -2,8 +2,12 @@ package merkletree
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -23,13 +27,58 @@ func TestBuildTree(t *testing.T) {
 		leafHashes = append(leafHashes, hash[:])
 	}
 
-	BuildTree(leafHashes)
-	got := hex.EncodeToString(Root.Hash[:])
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	got := hex.EncodeToString(tree.Root.Hash[:])
 	want := "1726c9d7c9f5585c6657edb9f5de6ee2f14c447d2fb80c9083a2572857702912"
 
 	if got != want {
 		t.Errorf("got %v, want %v", got, want)
 	}
+	if tree.LeafCount != len(leafData) {
+		t.Errorf("expected leaf count to be %d, got %d", len(leafData), tree.LeafCount)
+	}
+}
+
+func TestBuildTreeEmpty(t *testing.T) {
+	_, err := BuildTree(nil)
+	if err == nil {
+		t.Error("expected an error for empty leaves")
+	}
+}
+
+func TestBuildTreeIndependentTrees(t *testing.T) {
+	first := sha256.Sum256([]byte("hash1"))
+	second := sha256.Sum256([]byte("hash2"))
+
+	treeA, err := BuildTree([][]byte{first[:]})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	treeB, err := BuildTree([][]byte{first[:], second[:]})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if treeA.ID == treeB.ID {
+		t.Error("expected trees to have different IDs")
+	}
+	if !reflect.DeepEqual(treeA.RootHash(), first[:]) {
+		t.Errorf("building a second tree changed the first root to %x", treeA.RootHash())
+	}
+
+	proof, err := treeB.CreateProof(1)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if valid, _ := treeA.VerifyProof(second[:], proof); valid {
+		t.Error("expected proof from another tree to be invalid")
+	}
+	if valid, _ := treeB.VerifyProof(second[:], proof); !valid {
+		t.Error("expected proof to be valid for its own tree")
+	}
 }
 
 func TestCreateMerkleProof(t *testing.T) {
@@ -43,21 +92,30 @@ func TestCreateMerkleProof(t *testing.T) {
 		hash := sha256.Sum256([]byte(leaf))
 		leafHashes = append(leafHashes, hash[:])
 	}
-	BuildTree(leafHashes)
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
 
-	leafHash := sha256.Sum256([]byte("hash3"))
-	proof, err := CreateMerkleProof(Root, leafHash[:])
+	proof, err := tree.CreateProof(2)
 	if err != nil {
 		t.Errorf("returned unexpected error: %v", err)
 	}
-	if len(proof) != 2 {
-		t.Errorf("expected proof length to be 2, got %d", len(proof))
+	if len(proof.Siblings) != 2 {
+		t.Errorf("expected proof length to be 2, got %d", len(proof.Siblings))
 	}
 
-	invalidHash := []byte("invalidhash")
-	_, err = CreateMerkleProof(Root, invalidHash)
+	if proof.LeafIndex != 2 || proof.TreeSize != 3 {
+		t.Errorf("expected leaf index 2 and tree size 3, got %d and %d", proof.LeafIndex, proof.TreeSize)
+	}
+
+	_, err = tree.CreateProof(3)
 	if err == nil {
-		t.Error("expected an error for invalid hash")
+		t.Error("expected an error for out of range index")
+	}
+	_, err = tree.CreateProof(-1)
+	if err == nil {
+		t.Error("expected an error for negative index")
 	}
 }
 
@@ -72,18 +130,21 @@ func TestVerifyMerkleProof(t *testing.T) {
 		hash := sha256.Sum256([]byte(leaf))
 		leafHashes = append(leafHashes, hash[:])
 	}
-	BuildTree(leafHashes)
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
 
 	leafHash := sha256.Sum256([]byte("hash3"))
-	proof, _ := CreateMerkleProof(Root, leafHash[:])
+	proof, _ := tree.CreateProof(2)
 
-	valid, _ := VerifyMerkleProof(Root.Hash, leafHash[:], proof)
+	valid, _ := VerifyMerkleProof(tree.Root.Hash, leafHash[:], proof)
 	if !valid {
 		t.Error("Eepected true for valid hash")
 	}
 
-	proof[0].Hash = []byte("invalidHash")
-	valid, _ = VerifyMerkleProof(Root.Hash, leafHash[:], proof)
+	proof.Siblings[0].Hash = []byte("invalidHash")
+	valid, _ = tree.VerifyProof(leafHash[:], proof)
 	if valid {
 		t.Error("expected false for invalid hash")
 	}
@@ -108,7 +169,7 @@ func TestNewNode(t *testing.T) {
 func TestHashPair(t *testing.T) {
 	left := sha256.Sum256([]byte("hash1"))
 	right := sha256.Sum256([]byte("hash2"))
-	hashedPair := hashPair(left[:], right[:])
+	hashedPair := scheme{hasher: SHA256}.hashPair(left[:], right[:])
 
 	got := hex.EncodeToString(hashedPair)
 	want := "e6a8cc2a789a8e72fced42d013d87acb0c29f83e6d7716ab2bd92ee74f54a2da"
@@ -117,3 +178,592 @@ func TestHashPair(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestHashPairDomainSeparated(t *testing.T) {
+	left := sha256.Sum256([]byte("hash1"))
+	right := sha256.Sum256([]byte("hash2"))
+
+	plain := scheme{hasher: SHA256}.hashPair(left[:], right[:])
+	separated := scheme{hasher: SHA256, domainSeparated: true}.hashPair(left[:], right[:])
+	if reflect.DeepEqual(plain, separated) {
+		t.Error("expected domain separated hash to differ from the plain hash")
+	}
+
+	want := sha256.Sum256(append([]byte{nodePrefix}, append(left[:], right[:]...)...))
+	if !reflect.DeepEqual(separated, want[:]) {
+		t.Errorf("got %x, want %x", separated, want)
+	}
+}
+
+func TestDomainSeparationRejectsInteriorNodeAsLeaf(t *testing.T) {
+	leafData := []string{
+		"hash1",
+		"hash2",
+		"hash3",
+		"hash4",
+	}
+	var leafHashes [][]byte
+	for _, leaf := range leafData {
+		hash := sha256.Sum256([]byte(leaf))
+		leafHashes = append(leafHashes, hash[:])
+	}
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantPass bool
+	}{
+		{name: "plain", wantPass: true},
+		{name: "domain separated", opts: []Option{WithDomainSeparation()}, wantPass: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := BuildTree(leafHashes, tt.opts...)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			// A forged "file" made of the two children of the left interior node
+			left := tree.Root.Left
+			forgedFile := append(append([]byte{}, left.Left.Hash...), left.Right.Hash...)
+			forgedHash := sha256.Sum256(forgedFile)
+
+			forgedProof := MerkleProof{
+				DomainSeparated: tree.DomainSeparated,
+				Siblings:        []ProofSibling{{Hash: tree.Root.Right.Hash, IsLeft: false}},
+			}
+			valid, _ := VerifyMerkleProof(tree.RootHash(), forgedHash[:], forgedProof)
+			if valid != tt.wantPass {
+				t.Errorf("got %v, want %v", valid, tt.wantPass)
+			}
+
+			realProof, err := tree.CreateProof(0)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if valid, _ := tree.VerifyProof(leafHashes[0], realProof); !valid {
+				t.Error("expected true for valid hash")
+			}
+		})
+	}
+}
+
+func TestVerifyProofSchemeMismatch(t *testing.T) {
+	leafHash := sha256.Sum256([]byte("hash1"))
+	tree, err := BuildTree([][]byte{leafHash[:]}, WithDomainSeparation())
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, _ := tree.CreateProof(0)
+	proof.DomainSeparated = false
+	if valid, _ := tree.VerifyProof(leafHash[:], proof); valid {
+		t.Error("expected false for proof with a different scheme")
+	}
+}
+
+func TestMerkleProofUnmarshalLegacyJSON(t *testing.T) {
+	data := []byte(`[{"Hash":"aGFzaDE=","IsLeft":true},{"Hash":"aGFzaDI=","IsLeft":false}]`)
+
+	var proof MerkleProof
+	err := json.Unmarshal(data, &proof)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	want := MerkleProof{
+		Siblings: []ProofSibling{
+			{Hash: []byte("hash1"), IsLeft: true},
+			{Hash: []byte("hash2"), IsLeft: false},
+		},
+	}
+	if !reflect.DeepEqual(proof, want) {
+		t.Errorf("got %v, want %v", proof, want)
+	}
+
+	encoded, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	var roundTrip MerkleProof
+	err = json.Unmarshal(encoded, &roundTrip)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(roundTrip, want) {
+		t.Errorf("got %v, want %v", roundTrip, want)
+	}
+}
+
+func TestBuildTreeWithHasher(t *testing.T) {
+	leafData := []string{
+		"hash1",
+		"hash2",
+		"hash3",
+	}
+
+	for _, hasher := range []Hasher{SHA256, SHA512_256, SHA3_256, BLAKE2b256} {
+		t.Run(hasher.Name(), func(t *testing.T) {
+			var leafHashes [][]byte
+			for _, leaf := range leafData {
+				leafHashes = append(leafHashes, Sum(hasher, []byte(leaf)))
+			}
+
+			tree, err := BuildTree(leafHashes, WithHasher(hasher))
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if len(tree.RootHash()) != 32 {
+				t.Errorf("expected a 32 byte root, got %d bytes", len(tree.RootHash()))
+			}
+
+			proof, err := tree.CreateProof(2)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if proof.Algorithm != hasher.Name() {
+				t.Errorf("got algorithm %s, want %s", proof.Algorithm, hasher.Name())
+			}
+			if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[2], proof); !valid {
+				t.Error("expected true for valid hash")
+			}
+
+			proof.Algorithm = "unknown"
+			if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[2], proof); valid {
+				t.Error("expected false for unknown algorithm")
+			}
+		})
+	}
+}
+
+func TestVerifyProofAlgorithmMismatch(t *testing.T) {
+	leafHashes := [][]byte{Sum(SHA256, []byte("hash1")), Sum(SHA256, []byte("hash2"))}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, _ := tree.CreateProof(0)
+	proof.Algorithm = SHA3_256.Name()
+	if valid, _ := tree.VerifyProof(leafHashes[0], proof); valid {
+		t.Error("expected false for proof with a different algorithm")
+	}
+
+	proof.Algorithm = ""
+	if valid, _ := tree.VerifyProof(leafHashes[0], proof); !valid {
+		t.Error("expected proof without an algorithm to default to sha256")
+	}
+}
+
+func TestHasherByName(t *testing.T) {
+	custom := NewHasher("custom-sha256", SHA256.New)
+	_, err := HasherByName(custom.Name())
+	if err == nil {
+		t.Error("expected an error for unregistered hasher")
+	}
+
+	RegisterHasher(custom)
+	t.Cleanup(func() {
+		hashersMu.Lock()
+		defer hashersMu.Unlock()
+		delete(hashers, custom.Name())
+	})
+	got, err := HasherByName(custom.Name())
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if got.Name() != custom.Name() {
+		t.Errorf("got %v, want %v", got.Name(), custom.Name())
+	}
+}
+
+func TestCreateMerkleProofDuplicateLeaves(t *testing.T) {
+	duplicate := sha256.Sum256([]byte("hash1"))
+	other := sha256.Sum256([]byte("hash2"))
+	leafHashes := [][]byte{duplicate[:], other[:], duplicate[:]}
+
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for _, index := range []int{0, 2} {
+		proof, err := tree.CreateProof(index)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if proof.LeafIndex != index {
+			t.Errorf("got leaf index %d, want %d", proof.LeafIndex, index)
+		}
+		if valid, _ := tree.VerifyProof(duplicate[:], proof); !valid {
+			t.Errorf("expected true for duplicate leaf at index %d", index)
+		}
+	}
+}
+
+func TestVerifyMerkleProofIgnoresIsLeft(t *testing.T) {
+	var leafHashes [][]byte
+	for _, leaf := range []string{"hash1", "hash2", "hash3", "hash4", "hash5"} {
+		hash := sha256.Sum256([]byte(leaf))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for index, leafHash := range leafHashes {
+		proof, err := tree.CreateProof(index)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if valid, _ := tree.VerifyProof(leafHash, proof); !valid {
+			t.Errorf("expected true for leaf %d", index)
+		}
+
+		// Flipping the server supplied directions must not change the result
+		for i := range proof.Siblings {
+			proof.Siblings[i].IsLeft = !proof.Siblings[i].IsLeft
+		}
+		if valid, _ := tree.VerifyProof(leafHash, proof); !valid {
+			t.Errorf("expected IsLeft to be ignored for leaf %d", index)
+		}
+	}
+
+	proof, _ := tree.CreateProof(1)
+	proof.LeafIndex = 0
+	if valid, _ := tree.VerifyProof(leafHashes[1], proof); valid {
+		t.Error("expected false for proof with the wrong leaf index")
+	}
+
+	proof, _ = tree.CreateProof(1)
+	proof.LeafIndex = 5
+	if valid, _ := tree.VerifyProof(leafHashes[1], proof); valid {
+		t.Error("expected false for leaf index outside the tree")
+	}
+
+	proof, _ = tree.CreateProof(1)
+	proof.Siblings = proof.Siblings[:len(proof.Siblings)-1]
+	if valid, _ := tree.VerifyProof(leafHashes[1], proof); valid {
+		t.Error("expected false for proof with missing siblings")
+	}
+}
+
+func TestVerifyMerkleProofLegacy(t *testing.T) {
+	var leafHashes [][]byte
+	for _, leaf := range []string{"hash1", "hash2", "hash3"} {
+		hash := sha256.Sum256([]byte(leaf))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, _ := tree.CreateProof(1)
+	legacy := MerkleProof{Siblings: proof.Siblings}
+	if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[1], legacy); !valid {
+		t.Error("expected true for proof without a tree size")
+	}
+	if valid, _ := tree.VerifyProof(leafHashes[1], legacy); valid {
+		t.Error("expected the tree to reject a proof without a tree size")
+	}
+
+	// Placing the proof at the leaf's index ignores the flags
+	for i := range legacy.Siblings {
+		legacy.Siblings[i].IsLeft = !legacy.Siblings[i].IsLeft
+	}
+	legacy.LeafIndex = 1
+	legacy.TreeSize = tree.LeafCount
+	if valid, _ := tree.VerifyProof(leafHashes[1], legacy); !valid {
+		t.Error("expected true for proof placed at its leaf index")
+	}
+
+	legacy.TreeSize = 4
+	if valid, _ := tree.VerifyProof(leafHashes[1], legacy); valid {
+		t.Error("expected false for proof with a different tree size")
+	}
+}
+
+func TestBuildTreeWithWorkers(t *testing.T) {
+	for _, size := range []int{1, 5, minParallelNodes - 1, minParallelNodes*3 + 7} {
+		leafHashes := benchmarkLeaves(size)
+
+		serial, err := BuildTree(leafHashes, WithDomainSeparation())
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		parallel, err := BuildTree(leafHashes, WithDomainSeparation(), WithWorkers(7))
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(parallel.RootHash(), serial.RootHash()) {
+			t.Errorf("size %d: got %x, want %x", size, parallel.RootHash(), serial.RootHash())
+		}
+	}
+}
+
+func BenchmarkBuildTree(b *testing.B) {
+	leafHashes := benchmarkLeaves(1 << 18)
+
+	for _, workers := range []int{1, 2, 4, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := BuildTree(leafHashes, WithDomainSeparation(), WithWorkers(workers))
+				if err != nil {
+					b.Fatalf("returned unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkLeaves(size int) [][]byte {
+	leafHashes := make([][]byte, size)
+	for i := range leafHashes {
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], uint64(i))
+		hash := sha256.Sum256(data[:])
+		leafHashes[i] = hash[:]
+	}
+	return leafHashes
+}
+
+func TestOddNodeStrategies(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 3; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	padded := append(append([][]byte{}, leafHashes...), leafHashes[2])
+
+	tests := []struct {
+		strategy  OddNodeStrategy
+		wantEqual bool
+	}{
+		{strategy: OddNodeDuplicate, wantEqual: true},
+		{strategy: OddNodePromote, wantEqual: false},
+		{strategy: OddNodeZero, wantEqual: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			tree, err := BuildTree(leafHashes, WithOddNodeStrategy(tt.strategy))
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			paddedTree, err := BuildTree(padded, WithOddNodeStrategy(tt.strategy))
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			equal := reflect.DeepEqual(tree.RootHash(), paddedTree.RootHash())
+			if equal != tt.wantEqual {
+				t.Errorf("got equal roots %v for [a, b, c] and [a, b, c, c], want %v", equal, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestOddNodeStrategyProofs(t *testing.T) {
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+		t.Run(string(strategy), func(t *testing.T) {
+			var leafHashes [][]byte
+			for size := 1; size <= 17; size++ {
+				hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", size)))
+				leafHashes = append(leafHashes, hash[:])
+
+				tree, err := BuildTree(leafHashes, WithOddNodeStrategy(strategy))
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				for index := range leafHashes {
+					proof, err := tree.CreateProof(index)
+					if err != nil {
+						t.Fatalf("returned unexpected error: %v", err)
+					}
+					if proof.OddNode != strategy {
+						t.Errorf("got odd node strategy %s in proof, want %s", proof.OddNode, strategy)
+					}
+					if valid, _ := tree.VerifyProof(leafHashes[index], proof); !valid {
+						t.Errorf("size %d: expected true for leaf %d", size, index)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOddNodeStrategyMismatch(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 5; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes, WithOddNodeStrategy(OddNodeZero))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, err := tree.CreateProof(4)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	proof.OddNode = OddNodeDuplicate
+	if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[4], proof); valid {
+		t.Error("expected false for proof with a different odd node strategy")
+	}
+	if valid, _ := tree.VerifyProof(leafHashes[4], proof); valid {
+		t.Error("expected tree to reject proof with a different odd node strategy")
+	}
+
+	_, err = BuildTree(leafHashes, WithOddNodeStrategy("triplicate"))
+	if err == nil {
+		t.Error("expected an error for unknown odd node strategy")
+	}
+}
+
+func TestArityTestVectors(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 10; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+
+	// wantSiblings is the number of siblings in the proof for the last leaf
+	tests := []struct {
+		name         string
+		opts         []Option
+		wantRoot     string
+		wantSiblings int
+	}{
+		{
+			name:         "binary",
+			opts:         []Option{WithArity(2)},
+			wantRoot:     "a25f72653e3ea533565250cbe7182955fc0c9a58ada2b92938e4fe3fb00bab3b",
+			wantSiblings: 4,
+		},
+		{
+			name:         "arity 3 zero",
+			opts:         []Option{WithArity(3), WithOddNodeStrategy(OddNodeZero)},
+			wantRoot:     "fe43562e24029bba2f357e9364166b6da11efdaef19450d56c2862548888f4c0",
+			wantSiblings: 6,
+		},
+		{
+			name:         "arity 4",
+			opts:         []Option{WithArity(4)},
+			wantRoot:     "a1d8e543cc2c8f2aee28e7263672213596f0a15a68d5814994c888cd7faedf4c",
+			wantSiblings: 6,
+		},
+		{
+			name:         "arity 4 promote domain separated",
+			opts:         []Option{WithArity(4), WithOddNodeStrategy(OddNodePromote), WithDomainSeparation()},
+			wantRoot:     "e950247c75d71bf6f5bbf6ef221835929239072576322993195ad02dc1da6522",
+			wantSiblings: 3,
+		},
+		{
+			name:         "arity 16",
+			opts:         []Option{WithArity(16)},
+			wantRoot:     "5c9d8f9771c4829393da50900bf008364037f636c918ca72209afd8f1efc9de4",
+			wantSiblings: 15,
+		},
+		{
+			name:         "arity 16 zero domain separated",
+			opts:         []Option{WithArity(16), WithOddNodeStrategy(OddNodeZero), WithDomainSeparation()},
+			wantRoot:     "7337dddab885ec4b7bc144a8373873113f2eb94dfba33c7d297a042602e29913",
+			wantSiblings: 15,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := BuildTree(leafHashes, tt.opts...)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if got := hex.EncodeToString(tree.RootHash()); got != tt.wantRoot {
+				t.Errorf("got root %s, want %s", got, tt.wantRoot)
+			}
+
+			proof, err := tree.CreateProof(9)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if len(proof.Siblings) != tt.wantSiblings {
+				t.Errorf("got %d siblings, want %d", len(proof.Siblings), tt.wantSiblings)
+			}
+			if valid, _ := tree.VerifyProof(leafHashes[9], proof); !valid {
+				t.Error("expected proof for the last leaf to be valid")
+			}
+		})
+	}
+}
+
+func TestArityProofs(t *testing.T) {
+	for _, arity := range []int{3, 4, 16} {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+			t.Run(fmt.Sprintf("%d %s", arity, strategy), func(t *testing.T) {
+				var leafHashes [][]byte
+				for size := 1; size <= 40; size++ {
+					hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", size)))
+					leafHashes = append(leafHashes, hash[:])
+
+					tree, err := BuildTree(leafHashes, WithArity(arity), WithOddNodeStrategy(strategy))
+					if err != nil {
+						t.Fatalf("returned unexpected error: %v", err)
+					}
+					for index := range leafHashes {
+						proof, err := tree.CreateProof(index)
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+						if valid, _ := tree.VerifyProof(leafHashes[index], proof); !valid {
+							t.Errorf("size %d: expected true for leaf %d", size, index)
+						}
+
+						wrongIndex := proof
+						wrongIndex.LeafIndex = (index + 1) % size
+						if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[index], wrongIndex); valid && size > 1 {
+							t.Errorf("size %d: expected false for leaf %d at the wrong index", size, index)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestArityMismatch(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 6; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes, WithArity(4))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, err := tree.CreateProof(1)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	proof.Arity = 2
+	if valid, _ := VerifyMerkleProof(tree.RootHash(), leafHashes[1], proof); valid {
+		t.Error("expected false for proof with a different arity")
+	}
+	if valid, _ := tree.VerifyProof(leafHashes[1], proof); valid {
+		t.Error("expected tree to reject proof with a different arity")
+	}
+
+	for _, arity := range []int{-1, 1, MaxArity + 1} {
+		if _, err := BuildTree(leafHashes, WithArity(arity)); err == nil {
+			t.Errorf("expected an error for arity %d", arity)
+		}
+		if _, err := NewBuilder(WithArity(arity)).Root(); err == nil {
+			t.Errorf("expected builder to return an error for arity %d", arity)
+		}
+	}
+}
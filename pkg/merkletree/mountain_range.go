@@ -0,0 +1,194 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// MerkleMountainRange is a list of perfect binary trees, one for each set bit
+// of the number of leaves, largest first. Appending only ever merges trees of
+// the same height, so nodes are never rehashed and the path from a leaf to its
+// mountain's peak never changes as the range grows.
+type MerkleMountainRange struct {
+	Hasher          Hasher
+	DomainSeparated bool
+
+	scheme scheme
+	size   int
+	// levels[h] holds every node at height h in leaf order, including nodes
+	// that are now inside a larger mountain
+	levels [][][]byte
+}
+
+// MountainRangeProof holds the siblings from a leaf up to the peak of its
+// mountain, and every peak of the range the proof was made against.
+type MountainRangeProof struct {
+	Algorithm       string
+	DomainSeparated bool
+	LeafIndex       int
+	Size            int
+	Siblings        [][]byte
+	Peaks           [][]byte
+}
+
+func NewMerkleMountainRange(opts ...Option) *MerkleMountainRange {
+	config := &MerkleTree{Hasher: DefaultHasher}
+	for _, opt := range opts {
+		opt(config)
+	}
+	// Mountains are perfect binary trees, so the odd node strategy never
+	// applies
+	config.OddNode = OddNodeDuplicate
+	config.Arity = 2
+
+	return &MerkleMountainRange{
+		Hasher:          config.Hasher,
+		DomainSeparated: config.DomainSeparated,
+		scheme:          config.scheme(),
+	}
+}
+
+// Append adds a leaf and returns its index
+func (m *MerkleMountainRange) Append(hash []byte) int {
+	node := m.scheme.hashLeaf(hash)
+
+	// Merge with the peak to the left for as long as it has the same height
+	for height := 0; ; height++ {
+		if height == len(m.levels) {
+			m.levels = append(m.levels, nil)
+		}
+		m.levels[height] = append(m.levels[height], node)
+
+		level := m.levels[height]
+		if len(level)%2 == 1 {
+			break
+		}
+		node = m.scheme.hashPair(level[len(level)-2], level[len(level)-1])
+	}
+
+	m.size++
+	return m.size - 1
+}
+
+func (m *MerkleMountainRange) Size() int {
+	return m.size
+}
+
+// Peaks returns the root of every mountain, largest first
+func (m *MerkleMountainRange) Peaks() [][]byte {
+	var peaks [][]byte
+	for height := bits.Len(uint(m.size)) - 1; height >= 0; height-- {
+		if m.size&(1<<height) != 0 {
+			peaks = append(peaks, m.levels[height][m.size>>height-1])
+		}
+	}
+	return peaks
+}
+
+func (m *MerkleMountainRange) Root() ([]byte, error) {
+	if m.size == 0 {
+		return nil, errors.New("cannot get the root of a Merkle mountain range without any leaves")
+	}
+	return bagPeaks(m.scheme, m.Peaks()), nil
+}
+
+func (m *MerkleMountainRange) CreateProof(index int) (MountainRangeProof, error) {
+	if index < 0 || index >= m.size {
+		return MountainRangeProof{}, fmt.Errorf("leaf index %d out of range for mountain range of size %d", index, m.size)
+	}
+
+	proof := MountainRangeProof{
+		Algorithm:       m.Hasher.Name(),
+		DomainSeparated: m.DomainSeparated,
+		LeafIndex:       index,
+		Size:            m.size,
+		Peaks:           m.Peaks(),
+	}
+
+	_, height := mountainFor(index, m.size)
+	position := index
+	for level := 0; level < height; level++ {
+		proof.Siblings = append(proof.Siblings, m.levels[level][position^1])
+		position /= 2
+	}
+
+	return proof, nil
+}
+
+func (m *MerkleMountainRange) VerifyProof(hash []byte, proof MountainRangeProof) (bool, []byte) {
+	proofScheme, err := schemeFor(proof.Algorithm, proof.DomainSeparated, OddNodeDuplicate, 2)
+	if err != nil || !proofScheme.equal(m.scheme) {
+		return false, nil
+	}
+
+	root, err := m.Root()
+	if err != nil {
+		return false, nil
+	}
+	return VerifyMountainRangeProof(root, hash, proof)
+}
+
+// VerifyMountainRangeProof hashes the leaf up to its peak, checks it against
+// the peak in the proof and then bags the peaks into the root.
+func VerifyMountainRangeProof(rootHash []byte, hash []byte, proof MountainRangeProof) (bool, []byte) {
+	scheme, err := schemeFor(proof.Algorithm, proof.DomainSeparated, OddNodeDuplicate, 2)
+	if err != nil {
+		return false, nil
+	}
+
+	if proof.LeafIndex < 0 || proof.LeafIndex >= proof.Size || len(proof.Peaks) != bits.OnesCount(uint(proof.Size)) {
+		return false, nil
+	}
+	peak, height := mountainFor(proof.LeafIndex, proof.Size)
+	if len(proof.Siblings) != height {
+		return false, nil
+	}
+
+	currentHash := scheme.hashLeaf(hash)
+	position := proof.LeafIndex
+	for _, sibling := range proof.Siblings {
+		if position%2 == 1 {
+			currentHash = scheme.hashPair(sibling, currentHash)
+		} else {
+			currentHash = scheme.hashPair(currentHash, sibling)
+		}
+		position /= 2
+	}
+
+	if !bytes.Equal(currentHash, proof.Peaks[peak]) {
+		return false, nil
+	}
+
+	root := bagPeaks(scheme, proof.Peaks)
+	return bytes.Equal(root, rootHash), root
+}
+
+// mountainFor returns which mountain, counting from the largest, holds the
+// leaf at index, and that mountain's height
+func mountainFor(index int, size int) (int, int) {
+	peak := 0
+	start := 0
+	for height := bits.Len(uint(size)) - 1; height >= 0; height-- {
+		if size&(1<<height) == 0 {
+			continue
+		}
+		if index < start+1<<height {
+			return peak, height
+		}
+		start += 1 << height
+		peak++
+	}
+	return peak, 0
+}
+
+// bagPeaks folds the peaks together from the right, so a range with a power
+// of two leaves has the same root as BuildTree over the same leaves
+func bagPeaks(scheme scheme, peaks [][]byte) []byte {
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root = scheme.hashPair(peaks[i], root)
+	}
+	return root
+}
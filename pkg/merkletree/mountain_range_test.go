@@ -0,0 +1,133 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"testing"
+)
+
+func TestMerkleMountainRange(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "domain separated", opts: []Option{WithDomainSeparation()}},
+		{name: "sha3", opts: []Option{WithHasher(SHA3_256)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mmr := NewMerkleMountainRange(tt.opts...)
+			if _, err := mmr.Root(); err == nil {
+				t.Error("expected an error for empty mountain range")
+			}
+
+			var leafHashes [][]byte
+			for i := 0; i < 33; i++ {
+				hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+				leafHashes = append(leafHashes, hash[:])
+				if index := mmr.Append(hash[:]); index != i {
+					t.Errorf("got index %d, want %d", index, i)
+				}
+
+				size := mmr.Size()
+				if len(mmr.Peaks()) != bits.OnesCount(uint(size)) {
+					t.Errorf("size %d: got %d peaks, want %d", size, len(mmr.Peaks()), bits.OnesCount(uint(size)))
+				}
+
+				root, err := mmr.Root()
+				if err != nil {
+					t.Fatalf("returned unexpected error: %v", err)
+				}
+				if size&(size-1) == 0 {
+					tree, err := BuildTree(leafHashes, tt.opts...)
+					if err != nil {
+						t.Fatalf("returned unexpected error: %v", err)
+					}
+					if !reflect.DeepEqual(root, tree.RootHash()) {
+						t.Errorf("size %d: got root %x, want %x", size, root, tree.RootHash())
+					}
+				}
+
+				for index, leafHash := range leafHashes {
+					proof, err := mmr.CreateProof(index)
+					if err != nil {
+						t.Fatalf("returned unexpected error: %v", err)
+					}
+					if valid, _ := mmr.VerifyProof(leafHash, proof); !valid {
+						t.Errorf("size %d: expected proof for leaf %d to be valid", size, index)
+					}
+					if valid, _ := mmr.VerifyProof(leafHashes[(index+1)%len(leafHashes)], proof); valid && size > 1 {
+						t.Errorf("size %d: expected proof for leaf %d to be invalid for another leaf", size, index)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMountainRangeProofStaysValid(t *testing.T) {
+	mmr := NewMerkleMountainRange()
+	for i := 0; i < 5; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		mmr.Append(hash[:])
+	}
+	oldProof, err := mmr.CreateProof(2)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for i := 5; i < 40; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		mmr.Append(hash[:])
+
+		// The path to the old peak is still the start of the new path
+		proof, err := mmr.CreateProof(2)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(proof.Siblings[:len(oldProof.Siblings)], oldProof.Siblings) {
+			t.Errorf("size %d: expected the old siblings to be kept", mmr.Size())
+		}
+	}
+}
+
+func TestMountainRangeProofInvalid(t *testing.T) {
+	mmr := NewMerkleMountainRange()
+	var leafHashes [][]byte
+	for i := 0; i < 11; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+		mmr.Append(hash[:])
+	}
+
+	if _, err := mmr.CreateProof(11); err == nil {
+		t.Error("expected an error for a leaf index out of range")
+	}
+
+	proof, err := mmr.CreateProof(9)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	wrongIndex := proof
+	wrongIndex.LeafIndex = 8
+	if valid, _ := mmr.VerifyProof(leafHashes[9], wrongIndex); valid {
+		t.Error("expected proof with the wrong leaf index to be invalid")
+	}
+
+	missingPeak := proof
+	missingPeak.Peaks = proof.Peaks[1:]
+	if valid, _ := mmr.VerifyProof(leafHashes[9], missingPeak); valid {
+		t.Error("expected proof with a missing peak to be invalid")
+	}
+
+	wrongScheme := proof
+	wrongScheme.DomainSeparated = true
+	if valid, _ := mmr.VerifyProof(leafHashes[9], wrongScheme); valid {
+		t.Error("expected proof with a different scheme to be invalid")
+	}
+}
@@ -0,0 +1,215 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MultiProof proves several leaves at once. Hashes holds only the nodes that
+// can't be computed from the proven leaves, ordered bottom up and left to
+// right, so siblings shared by the leaves are sent once.
+type MultiProof struct {
+	Algorithm       string
+	DomainSeparated bool
+	OddNode         OddNodeStrategy `json:",omitempty"`
+	Arity           int             `json:",omitempty"`
+	TreeSize        int
+	LeafIndices     []int
+	Hashes          [][]byte
+}
+
+type indexedHash struct {
+	position int
+	hash     []byte
+}
+
+func (t *MerkleTree) CreateMultiProof(indices []int) (MultiProof, error) {
+	if len(indices) == 0 {
+		return MultiProof{}, errors.New("a multiproof needs at least one leaf index")
+	}
+
+	leaves := make([]indexedHash, 0, len(indices))
+	for _, index := range indices {
+		if index < 0 || index >= t.LeafCount {
+			return MultiProof{}, fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+		}
+		leaves = append(leaves, indexedHash{position: index, hash: t.levels[0][index].Hash})
+	}
+	leaves, err := sortLeaves(leaves)
+	if err != nil {
+		return MultiProof{}, err
+	}
+
+	proof := MultiProof{
+		Algorithm:       t.Hasher.Name(),
+		DomainSeparated: t.DomainSeparated,
+		OddNode:         t.OddNode,
+		Arity:           t.Arity,
+		TreeSize:        t.LeafCount,
+		LeafIndices:     append([]int{}, indices...),
+	}
+
+	computeRoot(t.scheme(), t.LeafCount, [][]indexedHash{leaves}, t.collectSiblings(&proof.Hashes))
+
+	return proof, nil
+}
+
+func (t *MerkleTree) VerifyMultiProof(leafHashes [][]byte, proof MultiProof) (bool, []byte) {
+	proofScheme, err := proof.scheme()
+	if err != nil || !proofScheme.equal(t.scheme()) || proof.TreeSize != t.LeafCount {
+		return false, nil
+	}
+	return VerifyMultiProof(t.Root.Hash, leafHashes, proof)
+}
+
+// VerifyMultiProof checks leafHashes, given in the same order as
+// proof.LeafIndices, against rootHash.
+func VerifyMultiProof(rootHash []byte, leafHashes [][]byte, proof MultiProof) (bool, []byte) {
+	scheme, err := proof.scheme()
+	if err != nil {
+		return false, nil
+	}
+
+	if len(leafHashes) == 0 || len(leafHashes) != len(proof.LeafIndices) {
+		return false, nil
+	}
+
+	nodes := make([]indexedHash, 0, len(leafHashes))
+	for i, index := range proof.LeafIndices {
+		if index < 0 || index >= proof.TreeSize {
+			return false, nil
+		}
+		nodes = append(nodes, indexedHash{
+			position: index,
+			hash:     scheme.hashLeaf(leafHashes[i]),
+		})
+	}
+	nodes, err = sortLeaves(nodes)
+	if err != nil {
+		return false, nil
+	}
+
+	hashes := proof.Hashes
+	root, ok := computeRoot(scheme, proof.TreeSize, [][]indexedHash{nodes}, consumeSiblings(&hashes))
+	if !ok || len(hashes) != 0 {
+		return false, nil
+	}
+
+	return bytes.Equal(root, rootHash), root
+}
+
+func (p MultiProof) scheme() (scheme, error) {
+	return schemeFor(p.Algorithm, p.DomainSeparated, p.OddNode, p.Arity)
+}
+
+func sortLeaves(leaves []indexedHash) ([]indexedHash, error) {
+	sorted := append([]indexedHash{}, leaves...)
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i].position < sorted[j].position
+	})
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].position == sorted[i-1].position {
+			return nil, fmt.Errorf("leaf index %d is included more than once", sorted[i].position)
+		}
+	}
+
+	return sorted, nil
+}
+
+// computeRoot hashes its way up a tree of treeSize leaves. known[level] holds
+// the nodes already known at that level, sorted by position. Any other
+// sibling is asked for in bottom up, left to right order, which is the order
+// proof hashes are stored in.
+func computeRoot(scheme scheme, treeSize int, known [][]indexedHash, sibling func(level int, position int) ([]byte, bool)) ([]byte, bool) {
+	var nodes []indexedHash
+	levelSize := treeSize
+	for level := 0; ; level++ {
+		if level < len(known) {
+			nodes = mergeNodes(nodes, known[level])
+		}
+		if levelSize == 1 {
+			break
+		}
+
+		var nextNodes []indexedHash
+		for i := 0; i < len(nodes); {
+			if nodes[i].position >= levelSize {
+				return nil, false
+			}
+
+			parent := nodes[i].position / scheme.arity
+			start := parent * scheme.arity
+			end := min(start+scheme.arity, levelSize)
+			children := make([][]byte, 0, end-start)
+			for position := start; position < end; position++ {
+				if i < len(nodes) && nodes[i].position == position {
+					children = append(children, nodes[i].hash)
+					i++
+					continue
+				}
+
+				siblingHash, ok := sibling(level, position)
+				if !ok {
+					return nil, false
+				}
+				children = append(children, siblingHash)
+			}
+
+			nextNodes = append(nextNodes, indexedHash{position: parent, hash: scheme.hashChildren(children)})
+		}
+		nodes = nextNodes
+		levelSize = scheme.parentCount(levelSize)
+	}
+
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	return nodes[0].hash, true
+}
+
+// collectSiblings looks siblings up in the tree and appends them to hashes
+func (t *MerkleTree) collectSiblings(hashes *[][]byte) func(level int, position int) ([]byte, bool) {
+	return func(level int, position int) ([]byte, bool) {
+		hash := t.levels[level][position].Hash
+		*hashes = append(*hashes, hash)
+		return hash, true
+	}
+}
+
+// consumeSiblings takes siblings from the front of hashes
+func consumeSiblings(hashes *[][]byte) func(level int, position int) ([]byte, bool) {
+	return func(level int, position int) ([]byte, bool) {
+		if len(*hashes) == 0 {
+			return nil, false
+		}
+		hash := (*hashes)[0]
+		*hashes = (*hashes)[1:]
+		return hash, true
+	}
+}
+
+func noSiblings(level int, position int) ([]byte, bool) {
+	return nil, false
+}
+
+func mergeNodes(a []indexedHash, b []indexedHash) []indexedHash {
+	if len(b) == 0 {
+		return a
+	}
+
+	merged := make([]indexedHash, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].position < b[0].position {
+			merged = append(merged, a[0])
+			a = a[1:]
+		} else {
+			merged = append(merged, b[0])
+			b = b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
@@ -0,0 +1,132 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestCreateMultiProof(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 8; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	// Leaves 0 and 1 share every sibling above them, so only the roots of
+	// the [2,3] and [4..7] subtrees are needed
+	proof, err := tree.CreateMultiProof([]int{1, 0})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if len(proof.Hashes) != 2 {
+		t.Errorf("expected 2 proof hashes, got %d", len(proof.Hashes))
+	}
+
+	_, err = tree.CreateMultiProof([]int{1, 1})
+	if err == nil {
+		t.Error("expected an error for duplicate indices")
+	}
+	_, err = tree.CreateMultiProof([]int{8})
+	if err == nil {
+		t.Error("expected an error for out of range index")
+	}
+	_, err = tree.CreateMultiProof(nil)
+	if err == nil {
+		t.Error("expected an error for no indices")
+	}
+}
+
+func TestVerifyMultiProof(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		indices []int
+		opts    []Option
+	}{
+		{name: "single leaf", size: 1, indices: []int{0}},
+		{name: "odd tree last leaf", size: 5, indices: []int{4}},
+		{name: "odd tree unsorted", size: 7, indices: []int{6, 0, 3}},
+		{name: "every leaf", size: 6, indices: []int{0, 1, 2, 3, 4, 5}},
+		{name: "domain separated", size: 11, indices: []int{2, 9, 10}, opts: []Option{WithDomainSeparation()}},
+		{name: "blake2b", size: 9, indices: []int{8, 1}, opts: []Option{WithHasher(BLAKE2b256)}},
+		{name: "promote odd nodes", size: 11, indices: []int{10, 3}, opts: []Option{WithOddNodeStrategy(OddNodePromote)}},
+		{name: "zero odd node siblings", size: 13, indices: []int{12, 5}, opts: []Option{WithOddNodeStrategy(OddNodeZero)}},
+		{name: "arity 4", size: 23, indices: []int{22, 0, 5, 6}, opts: []Option{WithArity(4)}},
+		{name: "arity 3 promote", size: 10, indices: []int{9, 4}, opts: []Option{WithArity(3), WithOddNodeStrategy(OddNodePromote)}},
+		{name: "arity 16 zero", size: 40, indices: []int{39, 17}, opts: []Option{WithArity(16), WithOddNodeStrategy(OddNodeZero)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leafHashes [][]byte
+			for i := 0; i < tt.size; i++ {
+				hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+				leafHashes = append(leafHashes, hash[:])
+			}
+			tree, err := BuildTree(leafHashes, tt.opts...)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			proof, err := tree.CreateMultiProof(tt.indices)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			var provenHashes [][]byte
+			for _, index := range tt.indices {
+				provenHashes = append(provenHashes, leafHashes[index])
+			}
+			if valid, _ := tree.VerifyMultiProof(provenHashes, proof); !valid {
+				t.Error("expected true for valid hashes")
+			}
+
+			corrupted := append([][]byte{}, provenHashes...)
+			corrupted[0] = []byte("invalidHash")
+			if valid, _ := tree.VerifyMultiProof(corrupted, proof); valid {
+				t.Error("expected false for invalid hash")
+			}
+
+			if valid, _ := tree.VerifyMultiProof(provenHashes[1:], proof); valid {
+				t.Error("expected false for missing leaf hashes")
+			}
+
+			if len(proof.Hashes) > 0 {
+				proof.Hashes = proof.Hashes[1:]
+				if valid, _ := tree.VerifyMultiProof(provenHashes, proof); valid {
+					t.Error("expected false for missing proof hashes")
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyMultiProofTreeSize(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 3; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	proof, err := tree.CreateMultiProof([]int{0})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	// Duplicating the last leaf makes a tree of 3 hash like a tree of 4
+	proof.TreeSize = 4
+	if valid, _ := VerifyMultiProof(tree.RootHash(), leafHashes[:1], proof); !valid {
+		t.Fatal("expected the root to match for the padded tree size")
+	}
+	if valid, _ := tree.VerifyMultiProof(leafHashes[:1], proof); valid {
+		t.Error("expected false for the wrong tree size")
+	}
+}
@@ -0,0 +1,214 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ProofEncodingVersion is the first byte of every binary encoded proof
+const ProofEncodingVersion = 1
+
+const domainSeparatedFlag = 1 << 0
+
+// The odd node strategy is kept in bits 1 and 2 of the flags, where 0 is
+// duplicate so proofs encoded before it was recorded still decode
+const (
+	oddNodeShift = 1
+	oddNodeMask  = 0b11 << oddNodeShift
+)
+
+var oddNodeCodes = []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero}
+
+// arityFlag is set when the tree isn't binary and the arity follows the flags
+const arityFlag = 1 << 3
+
+// MarshalBinary encodes the proof as:
+//
+//	version        1 byte
+//	algorithm      1 byte length, then the name
+//	flags          1 byte, bit 0 set for domain separation, bits 1-2 the
+//	               odd node strategy, bit 3 set when the arity follows
+//	arity          uvarint, only when the tree isn't binary
+//	leaf index     uvarint
+//	tree size      uvarint
+//	sibling count  uvarint
+//	hash size      uvarint
+//	siblings       count * hash size bytes
+//	directions     one bit per sibling, set when the sibling is on the left
+func (p MerkleProof) MarshalBinary() ([]byte, error) {
+	if len(p.Algorithm) > math.MaxUint8 {
+		return nil, fmt.Errorf("algorithm name too long: %s", p.Algorithm)
+	}
+	if p.LeafIndex < 0 || p.TreeSize < 0 {
+		return nil, errors.New("leaf index and tree size must not be negative")
+	}
+	err := validateArity(p.Arity)
+	if err != nil {
+		return nil, err
+	}
+	arity := defaultArity(p.Arity)
+
+	hashSize := 0
+	if len(p.Siblings) > 0 {
+		hashSize = len(p.Siblings[0].Hash)
+	}
+	for _, sibling := range p.Siblings {
+		if len(sibling.Hash) != hashSize {
+			return nil, errors.New("all sibling hashes must be the same size")
+		}
+	}
+
+	data := make([]byte, 0, 3+len(p.Algorithm)+4*binary.MaxVarintLen64+len(p.Siblings)*hashSize+(len(p.Siblings)+7)/8)
+	data = append(data, ProofEncodingVersion)
+	data = append(data, byte(len(p.Algorithm)))
+	data = append(data, p.Algorithm...)
+
+	var flags byte
+	if p.DomainSeparated {
+		flags |= domainSeparatedFlag
+	}
+	oddNodeCode := slices.Index(oddNodeCodes, defaultOddNode(p.OddNode))
+	if oddNodeCode < 0 {
+		return nil, fmt.Errorf("unknown odd node strategy: %s", p.OddNode)
+	}
+	flags |= byte(oddNodeCode) << oddNodeShift
+	if arity != 2 {
+		flags |= arityFlag
+	}
+	data = append(data, flags)
+
+	if arity != 2 {
+		data = binary.AppendUvarint(data, uint64(arity))
+	}
+
+	data = binary.AppendUvarint(data, uint64(p.LeafIndex))
+	data = binary.AppendUvarint(data, uint64(p.TreeSize))
+	data = binary.AppendUvarint(data, uint64(len(p.Siblings)))
+	data = binary.AppendUvarint(data, uint64(hashSize))
+
+	for _, sibling := range p.Siblings {
+		data = append(data, sibling.Hash...)
+	}
+
+	directions := make([]byte, (len(p.Siblings)+7)/8)
+	for i, sibling := range p.Siblings {
+		if sibling.IsLeft {
+			directions[i/8] |= 1 << (i % 8)
+		}
+	}
+	data = append(data, directions...)
+
+	return data, nil
+}
+
+func (p *MerkleProof) UnmarshalBinary(data []byte) error {
+	decoder := proofDecoder{data: data}
+
+	version := decoder.byte()
+	if decoder.err == nil && version != ProofEncodingVersion {
+		return fmt.Errorf("unsupported proof encoding version: %d", version)
+	}
+
+	algorithm := string(decoder.bytes(int(decoder.byte())))
+	flags := decoder.byte()
+	arity := 2
+	if flags&arityFlag != 0 {
+		arity = decoder.int()
+	}
+	leafIndex := decoder.int()
+	treeSize := decoder.int()
+	siblingCount := decoder.int()
+	hashSize := decoder.int()
+	if decoder.err != nil {
+		return decoder.err
+	}
+
+	// Check the sizes before allocating anything based on them
+	if hashSize > 0 && siblingCount > len(decoder.data)/hashSize {
+		return errors.New("proof is too short for its siblings")
+	}
+	hashes := decoder.bytes(siblingCount * hashSize)
+	directions := decoder.bytes((siblingCount + 7) / 8)
+	if decoder.err != nil {
+		return decoder.err
+	}
+	if len(decoder.data) != 0 {
+		return errors.New("unexpected data after proof")
+	}
+	if flags&^(domainSeparatedFlag|oddNodeMask|arityFlag) != 0 {
+		return fmt.Errorf("unknown proof flags: %08b", flags)
+	}
+	if arity < 2 || arity > MaxArity {
+		return fmt.Errorf("invalid arity in proof: %d", arity)
+	}
+	oddNodeCode := int(flags&oddNodeMask) >> oddNodeShift
+	if oddNodeCode >= len(oddNodeCodes) {
+		return fmt.Errorf("unknown odd node strategy code: %d", oddNodeCode)
+	}
+
+	proof := MerkleProof{
+		Algorithm:       algorithm,
+		DomainSeparated: flags&domainSeparatedFlag != 0,
+		OddNode:         oddNodeCodes[oddNodeCode],
+		Arity:           arity,
+		LeafIndex:       leafIndex,
+		TreeSize:        treeSize,
+	}
+	for i := 0; i < siblingCount; i++ {
+		hash := make([]byte, hashSize)
+		copy(hash, hashes[i*hashSize:])
+		proof.Siblings = append(proof.Siblings, ProofSibling{
+			Hash:   hash,
+			IsLeft: directions[i/8]&(1<<(i%8)) != 0,
+		})
+	}
+
+	*p = proof
+	return nil
+}
+
+// proofDecoder reads from data until the first error, after which every
+// read returns a zero value and err is kept.
+type proofDecoder struct {
+	data []byte
+	err  error
+}
+
+var errShortProof = errors.New("proof is too short")
+
+func (d *proofDecoder) byte() byte {
+	value := d.bytes(1)
+	if d.err != nil {
+		return 0
+	}
+	return value[0]
+}
+
+func (d *proofDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.err = errShortProof
+		return nil
+	}
+	value := d.data[:n]
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *proofDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 || value > math.MaxInt32 {
+		d.err = errors.New("invalid number in proof")
+		return 0
+	}
+	d.data = d.data[n:]
+	return int(value)
+}
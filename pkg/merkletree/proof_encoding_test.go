@@ -0,0 +1,107 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMerkleProofBinaryRoundTrip(t *testing.T) {
+	t.Run("binary", func(t *testing.T) {
+		testMerkleProofBinaryRoundTrip(t, WithHasher(SHA512_256), WithDomainSeparation(), WithOddNodeStrategy(OddNodeZero))
+	})
+	t.Run("arity 16", func(t *testing.T) {
+		testMerkleProofBinaryRoundTrip(t, WithArity(16), WithOddNodeStrategy(OddNodePromote))
+	})
+}
+
+func testMerkleProofBinaryRoundTrip(t *testing.T, opts ...Option) {
+	var leafHashes [][]byte
+	for i := 0; i < 13; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes, opts...)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	proof, err := tree.CreateProof(12)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	jsonData, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if len(data)*2 > len(jsonData) {
+		t.Errorf("expected binary proof of %d bytes to be under half the JSON size of %d bytes", len(data), len(jsonData))
+	}
+
+	var decoded MerkleProof
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, proof) {
+		t.Errorf("got %v, want %v", decoded, proof)
+	}
+	if valid, _ := tree.VerifyProof(leafHashes[12], decoded); !valid {
+		t.Error("expected decoded proof to be valid")
+	}
+}
+
+func TestMerkleProofUnmarshalBinaryInvalid(t *testing.T) {
+	hash := sha256.Sum256([]byte("hash1"))
+	proof := MerkleProof{
+		Algorithm: "sha256",
+		LeafIndex: 1,
+		TreeSize:  2,
+		Siblings:  []ProofSibling{{Hash: hash[:], IsLeft: true}},
+	}
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		var decoded MerkleProof
+		if err := decoded.UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("expected an error for proof truncated to %d bytes", i)
+		}
+	}
+
+	var decoded MerkleProof
+	if err := decoded.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Error("expected an error for trailing data")
+	}
+
+	badVersion := append([]byte{}, data...)
+	badVersion[0] = 2
+	if err := decoded.UnmarshalBinary(badVersion); err == nil {
+		t.Error("expected an error for unknown version")
+	}
+
+	// The flags follow the version and the algorithm name
+	flagsOffset := 2 + len(proof.Algorithm)
+	for _, flags := range []byte{0b110, 0b10000} {
+		badFlags := append([]byte{}, data...)
+		badFlags[flagsOffset] = flags
+		if err := decoded.UnmarshalBinary(badFlags); err == nil {
+			t.Errorf("expected an error for unknown flags %08b", flags)
+		}
+	}
+
+	proof.Siblings = append(proof.Siblings, ProofSibling{Hash: []byte("short")})
+	if _, err := proof.MarshalBinary(); err == nil {
+		t.Error("expected an error for siblings of different sizes")
+	}
+}
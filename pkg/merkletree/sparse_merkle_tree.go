@@ -0,0 +1,235 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// SparseMerkleTree has one leaf for every possible key, where a key is the
+// hash of a file name. Almost all leaves are empty, so only the non-empty
+// nodes are stored and empty subtrees hash to precomputed defaults. Because
+// every key has a fixed position, the tree can prove a file is absent as
+// well as present.
+//
+// Sparse trees are always domain separated, so a present leaf can never hash
+// like an empty one.
+type SparseMerkleTree struct {
+	Hasher Hasher
+
+	scheme   scheme
+	depth    int
+	rootPath string
+	values   map[string][]byte
+	nodes    map[string][]byte
+	defaults [][]byte
+}
+
+// SparseProof holds the siblings from the leaf up to the root, skipping any
+// that are empty subtrees. Bit h of Bitmap is set when the sibling at height h
+// is included.
+type SparseProof struct {
+	Algorithm string
+	Bitmap    []byte
+	Siblings  [][]byte
+}
+
+func NewSparseMerkleTree(opts ...Option) *SparseMerkleTree {
+	config := &MerkleTree{Hasher: DefaultHasher}
+	for _, opt := range opts {
+		opt(config)
+	}
+	config.DomainSeparated = true
+	// Every level is full, so the odd node strategy never applies, and the
+	// key bits pick a side at each level so the tree is always binary
+	config.OddNode = OddNodeDuplicate
+	config.Arity = 2
+
+	tree := &SparseMerkleTree{
+		Hasher: config.Hasher,
+		scheme: config.scheme(),
+		values: make(map[string][]byte),
+		nodes:  make(map[string][]byte),
+	}
+	tree.defaults = defaultHashes(tree.scheme)
+	tree.depth = len(tree.defaults) - 1
+	tree.rootPath = nodePath(make([]byte, tree.depth/8), tree.depth, tree.depth)
+
+	return tree
+}
+
+func (t *SparseMerkleTree) RootHash() []byte {
+	return t.node(t.depth, t.rootPath)
+}
+
+func (t *SparseMerkleTree) Get(name string) ([]byte, bool) {
+	value, ok := t.values[string(t.key(name))]
+	return value, ok
+}
+
+func (t *SparseMerkleTree) Set(name string, value []byte) {
+	key := t.key(name)
+	t.values[string(key)] = value
+	t.update(key, t.scheme.hashLeaf(value))
+}
+
+func (t *SparseMerkleTree) Delete(name string) {
+	key := t.key(name)
+	if _, ok := t.values[string(key)]; !ok {
+		return
+	}
+	delete(t.values, string(key))
+	t.update(key, t.defaults[0])
+}
+
+func (t *SparseMerkleTree) Len() int {
+	return len(t.values)
+}
+
+// CreateProof proves that name is in the tree when it has a value, and that
+// it is absent otherwise.
+func (t *SparseMerkleTree) CreateProof(name string) SparseProof {
+	key := t.key(name)
+	proof := SparseProof{
+		Algorithm: t.Hasher.Name(),
+		Bitmap:    make([]byte, (t.depth+7)/8),
+	}
+
+	for height := 0; height < t.depth; height++ {
+		sibling := t.node(height, siblingPath(key, height, t.depth))
+		if bytes.Equal(sibling, t.defaults[height]) {
+			continue
+		}
+		proof.Bitmap[height/8] |= 1 << (height % 8)
+		proof.Siblings = append(proof.Siblings, sibling)
+	}
+
+	return proof
+}
+
+func (t *SparseMerkleTree) VerifyProof(name string, value []byte, proof SparseProof) (bool, []byte) {
+	proofScheme, err := schemeFor(proof.Algorithm, true, OddNodeDuplicate, 2)
+	if err != nil || !proofScheme.equal(t.scheme) {
+		return false, nil
+	}
+	return VerifySparseProof(t.RootHash(), name, value, proof)
+}
+
+// VerifySparseProof checks that name maps to value in the tree with rootHash.
+// A nil value checks that name is absent.
+func VerifySparseProof(rootHash []byte, name string, value []byte, proof SparseProof) (bool, []byte) {
+	scheme, err := schemeFor(proof.Algorithm, true, OddNodeDuplicate, 2)
+	if err != nil {
+		return false, nil
+	}
+
+	defaults := defaultHashes(scheme)
+	depth := len(defaults) - 1
+	if len(proof.Bitmap) != (depth+7)/8 {
+		return false, nil
+	}
+
+	key := Sum(scheme.hasher, []byte(name))
+	currentHash := defaults[0]
+	if value != nil {
+		currentHash = scheme.hashLeaf(value)
+	}
+
+	siblings := proof.Siblings
+	for height := 0; height < depth; height++ {
+		sibling := defaults[height]
+		if proof.Bitmap[height/8]&(1<<(height%8)) != 0 {
+			if len(siblings) == 0 {
+				return false, nil
+			}
+			sibling = siblings[0]
+			siblings = siblings[1:]
+		}
+
+		if keyBit(key, depth-1-height) {
+			currentHash = scheme.hashPair(sibling, currentHash)
+		} else {
+			currentHash = scheme.hashPair(currentHash, sibling)
+		}
+	}
+
+	if len(siblings) != 0 {
+		return false, nil
+	}
+
+	return bytes.Equal(currentHash, rootHash), currentHash
+}
+
+func (t *SparseMerkleTree) key(name string) []byte {
+	return Sum(t.Hasher, []byte(name))
+}
+
+// update stores leafHash for key and rehashes its path up to the root.
+// Nodes equal to the default for their height are not stored.
+func (t *SparseMerkleTree) update(key []byte, leafHash []byte) {
+	currentHash := leafHash
+	for height := 0; ; height++ {
+		path := nodePath(key, height, t.depth)
+		if bytes.Equal(currentHash, t.defaults[height]) {
+			delete(t.nodes, path)
+		} else {
+			t.nodes[path] = currentHash
+		}
+		if height == t.depth {
+			return
+		}
+
+		sibling := t.node(height, siblingPath(key, height, t.depth))
+		if keyBit(key, t.depth-1-height) {
+			currentHash = t.scheme.hashPair(sibling, currentHash)
+		} else {
+			currentHash = t.scheme.hashPair(currentHash, sibling)
+		}
+	}
+}
+
+// node returns the stored hash at path, or the default for its height
+func (t *SparseMerkleTree) node(height int, path string) []byte {
+	if hash, ok := t.nodes[path]; ok {
+		return hash
+	}
+	return t.defaults[height]
+}
+
+// nodePath identifies the node at height above the leaf for key: the height
+// followed by the key with the bits below that node cleared.
+func nodePath(key []byte, height int, depth int) string {
+	path := make([]byte, 2+len(key))
+	binary.BigEndian.PutUint16(path, uint16(height))
+	copy(path[2:], key)
+	for bit := depth - height; bit < depth; bit++ {
+		path[2+bit/8] &^= 0x80 >> (bit % 8)
+	}
+	return string(path)
+}
+
+func siblingPath(key []byte, height int, depth int) string {
+	path := []byte(nodePath(key, height, depth))
+	bit := depth - 1 - height
+	path[2+bit/8] ^= 0x80 >> (bit % 8)
+	return string(path)
+}
+
+// keyBit reports whether the path to key goes right at the given depth,
+// counting from the root.
+func keyBit(key []byte, bit int) bool {
+	return key[bit/8]&(0x80>>(bit%8)) != 0
+}
+
+// defaultHashes returns the hash of an empty subtree for every height from
+// an empty leaf up to the root.
+func defaultHashes(scheme scheme) [][]byte {
+	size := scheme.hasher.New().Size()
+	depth := size * 8
+
+	defaults := make([][]byte, depth+1)
+	defaults[0] = make([]byte, size)
+	for height := 1; height <= depth; height++ {
+		defaults[height] = scheme.hashPair(defaults[height-1], defaults[height-1])
+	}
+	return defaults
+}
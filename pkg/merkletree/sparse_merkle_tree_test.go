@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSparseMerkleTree(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	emptyRoot := tree.RootHash()
+
+	for i := 0; i < 10; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("Hello %d", i)))
+		tree.Set(fmt.Sprintf("%d.txt", i), hash[:])
+	}
+	if tree.Len() != 10 {
+		t.Errorf("expected 10 values, got %d", tree.Len())
+	}
+
+	// The root only depends on the set of values, not the order they were set
+	other := NewSparseMerkleTree()
+	for i := 9; i >= 0; i-- {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("Hello %d", i)))
+		other.Set(fmt.Sprintf("%d.txt", i), hash[:])
+	}
+	if !reflect.DeepEqual(tree.RootHash(), other.RootHash()) {
+		t.Errorf("got %x, want %x", other.RootHash(), tree.RootHash())
+	}
+
+	for i := 0; i < 10; i++ {
+		other.Delete(fmt.Sprintf("%d.txt", i))
+	}
+	if !reflect.DeepEqual(other.RootHash(), emptyRoot) {
+		t.Errorf("expected the root of an emptied tree to be %x, got %x", emptyRoot, other.RootHash())
+	}
+	if len(other.nodes) != 0 {
+		t.Errorf("expected no stored nodes in an emptied tree, got %d", len(other.nodes))
+	}
+}
+
+func TestSparseMerkleTreeInclusionProof(t *testing.T) {
+	tree := NewSparseMerkleTree(WithHasher(BLAKE2b256))
+	for i := 0; i < 10; i++ {
+		tree.Set(fmt.Sprintf("%d.txt", i), Sum(BLAKE2b256, []byte(fmt.Sprintf("Hello %d", i))))
+	}
+
+	value, ok := tree.Get("3.txt")
+	if !ok {
+		t.Fatal("expected 3.txt to be in the tree")
+	}
+	proof := tree.CreateProof("3.txt")
+	if valid, _ := tree.VerifyProof("3.txt", value, proof); !valid {
+		t.Error("expected true for included file")
+	}
+	if valid, _ := tree.VerifyProof("3.txt", nil, proof); valid {
+		t.Error("expected false for exclusion of an included file")
+	}
+	if valid, _ := tree.VerifyProof("3.txt", []byte("invalidHash"), proof); valid {
+		t.Error("expected false for the wrong value")
+	}
+	if valid, _ := tree.VerifyProof("4.txt", value, proof); valid {
+		t.Error("expected false for another file name")
+	}
+
+	proof.Algorithm = SHA256.Name()
+	if valid, _ := tree.VerifyProof("3.txt", value, proof); valid {
+		t.Error("expected false for proof with a different algorithm")
+	}
+}
+
+func TestSparseMerkleTreeExclusionProof(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	for i := 0; i < 10; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("Hello %d", i)))
+		tree.Set(fmt.Sprintf("%d.txt", i), hash[:])
+	}
+	deleted, _ := tree.Get("5.txt")
+	tree.Delete("5.txt")
+
+	proof := tree.CreateProof("5.txt")
+	if valid, _ := VerifySparseProof(tree.RootHash(), "5.txt", nil, proof); !valid {
+		t.Error("expected true for deleted file")
+	}
+	if valid, _ := VerifySparseProof(tree.RootHash(), "5.txt", deleted, proof); valid {
+		t.Error("expected false for inclusion of a deleted file")
+	}
+
+	proof = tree.CreateProof("6.txt")
+	if valid, _ := VerifySparseProof(tree.RootHash(), "6.txt", nil, proof); valid {
+		t.Error("expected false for exclusion of an included file")
+	}
+
+	proof = tree.CreateProof("missing.txt")
+	proof.Siblings = proof.Siblings[1:]
+	if valid, _ := VerifySparseProof(tree.RootHash(), "missing.txt", nil, proof); valid {
+		t.Error("expected false for proof with missing siblings")
+	}
+}
@@ -0,0 +1,224 @@
+package merkletree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageFormatVersion is written to every saved tree. Load refuses files
+// from a newer version it doesn't know how to read.
+const StorageFormatVersion = 4
+
+type StorageMode string
+
+const (
+	// StoreFullTree saves every node as well. Loading rebuilds the tree from
+	// the leaves and checks every saved node against it.
+	StoreFullTree StorageMode = "full"
+	// StoreLeaves saves only the leaves and root, and rebuilds the rest on load
+	StoreLeaves StorageMode = "leaves"
+)
+
+type storedTree struct {
+	Version         int
+	Mode            StorageMode
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	Algorithm       string
+	DomainSeparated bool
+	LeafOrder       LeafOrder
+	OddNode         OddNodeStrategy `json:",omitempty"`
+	Arity           int             `json:",omitempty"`
+	ChunkSize       int             `json:",omitempty"`
+	LeafEncoding    LeafEncoding    `json:",omitempty"`
+	LeafCount       int
+	RootHash        []byte
+	Leaves          [][]byte
+	Names           []string   `json:",omitempty"`
+	Levels          [][][]byte `json:",omitempty"`
+}
+
+func (t *MerkleTree) Save(w io.Writer, mode StorageMode) error {
+	stored := storedTree{
+		Version:         StorageFormatVersion,
+		Mode:            mode,
+		ID:              t.ID,
+		CreatedAt:       t.CreatedAt,
+		Algorithm:       t.Hasher.Name(),
+		DomainSeparated: t.DomainSeparated,
+		LeafOrder:       t.LeafOrder,
+		OddNode:         t.OddNode,
+		Arity:           t.Arity,
+		ChunkSize:       t.ChunkSize,
+		LeafEncoding:    t.LeafEncoding,
+		LeafCount:       t.LeafCount,
+		RootHash:        t.Root.Hash,
+		Leaves:          t.leaves,
+		Names:           t.names,
+	}
+
+	switch mode {
+	case StoreLeaves:
+	case StoreFullTree:
+		for _, level := range t.levels {
+			hashes := make([][]byte, len(level))
+			for i, node := range level {
+				hashes[i] = node.Hash
+			}
+			stored.Levels = append(stored.Levels, hashes)
+		}
+	default:
+		return fmt.Errorf("unknown storage mode: %s", mode)
+	}
+
+	return json.NewEncoder(w).Encode(stored)
+}
+
+// SaveFile writes to a temporary file first so a crash can't leave a half
+// written tree behind.
+func (t *MerkleTree) SaveFile(path string, mode StorageMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	err = t.Save(writer, mode)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// Load reads a tree written by Save and checks that its root matches the
+// stored root hash.
+func Load(r io.Reader) (*MerkleTree, error) {
+	var stored storedTree
+	err := json.NewDecoder(r).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.Version < 1 || stored.Version > StorageFormatVersion {
+		return nil, fmt.Errorf("unsupported Merkle tree format version: %d", stored.Version)
+	}
+	if stored.LeafCount == 0 || len(stored.Leaves) != stored.LeafCount {
+		return nil, fmt.Errorf("expected %d leaves in stored Merkle tree, got %d", stored.LeafCount, len(stored.Leaves))
+	}
+
+	if stored.ChunkSize < 0 {
+		return nil, fmt.Errorf("invalid chunk size in stored Merkle tree: %d", stored.ChunkSize)
+	}
+
+	hasher, err := HasherByName(stored.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = validateOddNode(stored.OddNode)
+	if err != nil {
+		return nil, err
+	}
+	err = validateArity(stored.Arity)
+	if err != nil {
+		return nil, err
+	}
+	err = validateLeafEncoding(stored.LeafEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.LeafOrder == LeafOrderSortedHash {
+		isSorted := sort.SliceIsSorted(stored.Leaves, func(i int, j int) bool {
+			return bytes.Compare(stored.Leaves[i], stored.Leaves[j]) < 0
+		})
+		if !isSorted {
+			return nil, errors.New("stored Merkle tree leaves are not sorted by hash")
+		}
+	}
+
+	tree := &MerkleTree{
+		ID:              stored.ID,
+		LeafCount:       stored.LeafCount,
+		Hasher:          hasher,
+		DomainSeparated: stored.DomainSeparated,
+		LeafOrder:       stored.LeafOrder,
+		OddNode:         defaultOddNode(stored.OddNode),
+		Arity:           defaultArity(stored.Arity),
+		ChunkSize:       stored.ChunkSize,
+		LeafEncoding:    defaultLeafEncoding(stored.LeafEncoding),
+		CreatedAt:       stored.CreatedAt,
+		leaves:          stored.Leaves,
+		names:           stored.Names,
+	}
+	err = tree.indexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	switch stored.Mode {
+	case StoreLeaves:
+		tree.buildLevels()
+	case StoreFullTree:
+		tree.buildLevels()
+		err = tree.checkLevels(stored.Levels)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown storage mode: %s", stored.Mode)
+	}
+
+	if !bytes.Equal(tree.Root.Hash, stored.RootHash) {
+		return nil, errors.New("stored Merkle tree does not match its root hash")
+	}
+
+	return tree, nil
+}
+
+func LoadFile(path string) (*MerkleTree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Load(bufio.NewReader(file))
+}
+
+// checkLevels checks saved level hashes against the tree rebuilt from its
+// leaves, so a node that was changed on disk isn't trusted
+func (t *MerkleTree) checkLevels(levels [][][]byte) error {
+	if len(levels) != len(t.levels) {
+		return errors.New("stored Merkle tree has the wrong number of levels")
+	}
+
+	for i, hashes := range levels {
+		if len(hashes) != len(t.levels[i]) {
+			return fmt.Errorf("expected %d nodes at level %d of stored Merkle tree, got %d", len(t.levels[i]), i, len(hashes))
+		}
+		for j, hash := range hashes {
+			if !bytes.Equal(hash, t.levels[i][j].Hash) {
+				return fmt.Errorf("node %d at level %d of stored Merkle tree does not match its children", j, i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 7; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	sort.Slice(leafHashes, func(i int, j int) bool {
+		return bytes.Compare(leafHashes[i], leafHashes[j]) < 0
+	})
+
+	tree, err := BuildTree(leafHashes, WithHasher(SHA3_256), WithDomainSeparation(), WithLeafOrder(LeafOrderSortedHash), WithOddNodeStrategy(OddNodeZero), WithChunkSize(1024), WithLeafEncoding(LeafEncodingMetadata), WithLeafNames([]string{"a", "b", "c", "d", "e", "f", "g"}))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for _, mode := range []StorageMode{StoreFullTree, StoreLeaves} {
+		t.Run(string(mode), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tree.json")
+			err := tree.SaveFile(path, mode)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			loaded, err := LoadFile(path)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(loaded.RootHash(), tree.RootHash()) {
+				t.Errorf("got root %x, want %x", loaded.RootHash(), tree.RootHash())
+			}
+			if loaded.ID != tree.ID || !loaded.CreatedAt.Equal(tree.CreatedAt) {
+				t.Errorf("expected ID and creation time to be kept, got %v and %v", loaded.ID, loaded.CreatedAt)
+			}
+			if loaded.Hasher.Name() != SHA3_256.Name() || !loaded.DomainSeparated || loaded.LeafOrder != LeafOrderSortedHash {
+				t.Errorf("expected the hashing scheme and leaf order to be kept, got %s %v %s", loaded.Hasher.Name(), loaded.DomainSeparated, loaded.LeafOrder)
+			}
+			if loaded.OddNode != OddNodeZero {
+				t.Errorf("got odd node strategy %s, want %s", loaded.OddNode, OddNodeZero)
+			}
+			if index, ok := loaded.LeafIndex("e"); !ok || index != 4 {
+				t.Errorf("expected leaf names to be kept, got index %d for e", index)
+			}
+			if loaded.LeafEncoding != LeafEncodingMetadata {
+				t.Errorf("got leaf encoding %s, want %s", loaded.LeafEncoding, LeafEncodingMetadata)
+			}
+			if loaded.ChunkSize != 1024 {
+				t.Errorf("got chunk size %d, want 1024", loaded.ChunkSize)
+			}
+
+			proof, err := loaded.CreateProof(4)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if valid, _ := tree.VerifyProof(leafHashes[4], proof); !valid {
+				t.Error("expected proof from the loaded tree to be valid")
+			}
+			if loaded.Root.Left == nil || loaded.Root.Right == nil {
+				t.Error("expected the loaded root to be linked to its children")
+			}
+		})
+	}
+}
+
+func TestSaveAndLoadArity(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 21; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes, WithArity(4), WithOddNodeStrategy(OddNodePromote))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	for _, mode := range []StorageMode{StoreFullTree, StoreLeaves} {
+		t.Run(string(mode), func(t *testing.T) {
+			var saved bytes.Buffer
+			err := tree.Save(&saved, mode)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+
+			loaded, err := Load(&saved)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if loaded.Arity != 4 {
+				t.Errorf("got arity %d, want 4", loaded.Arity)
+			}
+			if len(loaded.Root.Children) != 2 {
+				t.Errorf("expected the loaded root to be linked to 2 children, got %d", len(loaded.Root.Children))
+			}
+
+			proof, err := loaded.CreateProof(20)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			if valid, _ := tree.VerifyProof(leafHashes[20], proof); !valid {
+				t.Error("expected proof from the loaded tree to be valid")
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	hash := sha256.Sum256([]byte("hash1"))
+	tree, err := BuildTree([][]byte{hash[:], hash[:], hash[:]})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	var saved bytes.Buffer
+	err = tree.Save(&saved, StoreLeaves)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		replace string
+		with    string
+	}{
+		{name: "future version", replace: `"Version":4`, with: `"Version":5`},
+		{name: "unknown algorithm", replace: `"Algorithm":"sha256"`, with: `"Algorithm":"md5"`},
+		{name: "unknown mode", replace: `"Mode":"leaves"`, with: `"Mode":"partial"`},
+		{name: "wrong leaf count", replace: `"LeafCount":3`, with: `"LeafCount":4`},
+		{name: "wrong root", replace: `"DomainSeparated":false`, with: `"DomainSeparated":true`},
+		{name: "unknown odd node strategy", replace: `"OddNode":"duplicate"`, with: `"OddNode":"triplicate"`},
+		{name: "wrong odd node strategy", replace: `"OddNode":"duplicate"`, with: `"OddNode":"promote"`},
+		{name: "invalid arity", replace: `"Arity":2`, with: `"Arity":1`},
+		{name: "wrong arity", replace: `"Arity":2`, with: `"Arity":3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := strings.Replace(saved.String(), tt.replace, tt.with, 1)
+			if data == saved.String() {
+				t.Fatalf("%s not found in saved tree", tt.replace)
+			}
+			_, err := Load(strings.NewReader(data))
+			if err == nil {
+				t.Error("expected an error for invalid tree")
+			}
+		})
+	}
+}
+
+func TestLoadFullTreeChangedNode(t *testing.T) {
+	var leafHashes [][]byte
+	for i := 0; i < 5; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+	}
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	var saved bytes.Buffer
+	err = tree.Save(&saved, StoreFullTree)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	// Every level below the root, with the root hash left as it was
+	for level := 0; level < len(tree.levels)-1; level++ {
+		var stored storedTree
+		json.Unmarshal(saved.Bytes(), &stored)
+		stored.Levels[level][1] = leafHashes[0]
+		data, _ := json.Marshal(stored)
+
+		if _, err := Load(bytes.NewReader(data)); err == nil {
+			t.Errorf("level %d: expected an error for a changed node", level)
+		}
+	}
+}
+
+func TestLoadVersion1(t *testing.T) {
+	hash := sha256.Sum256([]byte("hash1"))
+	tree, err := BuildTree([][]byte{hash[:], hash[:], hash[:]})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	var saved bytes.Buffer
+	err = tree.Save(&saved, StoreFullTree)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	// Version 1 didn't record the odd node strategy and always duplicated
+	if !strings.Contains(saved.String(), `"OddNode":"duplicate",`) {
+		t.Fatal("odd node strategy not found in saved tree")
+	}
+	data := strings.Replace(saved.String(), `"Version":4`, `"Version":1`, 1)
+	data = strings.Replace(data, `"OddNode":"duplicate",`, "", 1)
+	loaded, err := Load(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if loaded.OddNode != OddNodeDuplicate {
+		t.Errorf("got odd node strategy %s, want %s", loaded.OddNode, OddNodeDuplicate)
+	}
+}
@@ -0,0 +1,281 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// WithLeafNames names each leaf, in the same order as the hashes, so leaves
+// can be looked up, updated and removed by file name.
+func WithLeafNames(names []string) Option {
+	return func(t *MerkleTree) {
+		t.names = append([]string{}, names...)
+	}
+}
+
+func (t *MerkleTree) HasLeafNames() bool {
+	return t.names != nil
+}
+
+// LeafIndex finds the leaf with the given file name
+func (t *MerkleTree) LeafIndex(name string) (int, bool) {
+	index, ok := t.nameIndex[name]
+	return index, ok
+}
+
+func (t *MerkleTree) LeafName(index int) (string, error) {
+	if index < 0 || index >= t.LeafCount {
+		return "", fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+	}
+	if t.names == nil {
+		return "", errors.New("tree has no leaf names")
+	}
+	return t.names[index], nil
+}
+
+// indexNames checks there is one unique name per leaf and indexes them
+func (t *MerkleTree) indexNames() error {
+	if t.names == nil {
+		return nil
+	}
+	if len(t.names) != t.LeafCount {
+		return fmt.Errorf("expected %d leaf names, got %d", t.LeafCount, len(t.names))
+	}
+
+	t.nameIndex = make(map[string]int, len(t.names))
+	for i, name := range t.names {
+		if name == "" {
+			continue
+		}
+		if _, ok := t.nameIndex[name]; ok {
+			return fmt.Errorf("leaf name %s is used more than once", name)
+		}
+		t.nameIndex[name] = i
+	}
+	return nil
+}
+
+// AppendNamed adds a named leaf to the end of the tree
+func (t *MerkleTree) AppendNamed(name string, hash []byte) error {
+	if t.names == nil {
+		return errors.New("tree has no leaf names")
+	}
+	if _, ok := t.nameIndex[name]; ok {
+		return fmt.Errorf("leaf name %s is used more than once", name)
+	}
+
+	t.Append(hash)
+	t.names[t.LeafCount-1] = name
+	t.nameIndex[name] = t.LeafCount - 1
+	return nil
+}
+
+// Update replaces the leaf at index, only rehashing the nodes on its path to
+// the root.
+func (t *MerkleTree) Update(index int, hash []byte) error {
+	if index < 0 || index >= t.LeafCount {
+		return fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+	}
+
+	t.setLeaf(index, hash)
+	t.checkOrderAround(index)
+	return nil
+}
+
+func (t *MerkleTree) UpdateByName(name string, hash []byte) error {
+	index, ok := t.LeafIndex(name)
+	if !ok {
+		return fmt.Errorf("no leaf named %s", name)
+	}
+	return t.Update(index, hash)
+}
+
+// Remove moves the last leaf into the place of the one at index and then
+// drops the last leaf, so only two paths to the root are rehashed. The
+// order of the remaining leaves is otherwise kept.
+func (t *MerkleTree) Remove(index int) error {
+	if index < 0 || index >= t.LeafCount {
+		return fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.LeafCount)
+	}
+	if t.LeafCount == 1 {
+		return errors.New("cannot remove the only leaf of a Merkle tree")
+	}
+
+	last := t.LeafCount - 1
+	if t.names != nil {
+		delete(t.nameIndex, t.names[index])
+		if index != last {
+			t.names[index] = t.names[last]
+			if t.names[index] != "" {
+				t.nameIndex[t.names[index]] = index
+			}
+		}
+		t.names = t.names[:last]
+	}
+	if index != last {
+		t.setLeaf(index, t.leaves[last])
+	}
+	t.removeLast()
+
+	if index != last {
+		t.checkOrderAround(index)
+	}
+	return nil
+}
+
+func (t *MerkleTree) RemoveByName(name string) error {
+	index, ok := t.LeafIndex(name)
+	if !ok {
+		return fmt.Errorf("no leaf named %s", name)
+	}
+	return t.Remove(index)
+}
+
+// ApplySorted changes a tree whose leaves are sorted by hash and keeps them
+// sorted: each leaf named in set is replaced or added and each leaf named in
+// remove is dropped. Only the nodes from the first leaf that moves onwards
+// are rehashed, so the root matches a tree built from scratch over the same
+// sorted leaves.
+func (t *MerkleTree) ApplySorted(set map[string][]byte, remove []string) error {
+	if t.names == nil {
+		return errors.New("tree has no leaf names")
+	}
+	if t.LeafOrder != LeafOrderSortedHash {
+		return errors.New("tree leaves are not sorted by hash")
+	}
+
+	dropped := make(map[string]bool, len(set)+len(remove))
+	for _, name := range remove {
+		if _, ok := t.nameIndex[name]; !ok {
+			return fmt.Errorf("no leaf named %s", name)
+		}
+		dropped[name] = true
+	}
+	for name := range set {
+		dropped[name] = true
+	}
+
+	var addedNames []string
+	for name := range set {
+		addedNames = append(addedNames, name)
+	}
+	sort.Slice(addedNames, func(i int, j int) bool {
+		return bytes.Compare(set[addedNames[i]], set[addedNames[j]]) < 0
+	})
+
+	// Merge the kept leaves with the added ones, which are both in hash order
+	leaves := make([][]byte, 0, t.LeafCount+len(set))
+	names := make([]string, 0, t.LeafCount+len(set))
+	next := 0
+	for i := 0; i <= t.LeafCount; i++ {
+		for next < len(addedNames) && (i == t.LeafCount || bytes.Compare(set[addedNames[next]], t.leaves[i]) < 0) {
+			leaves = append(leaves, set[addedNames[next]])
+			names = append(names, addedNames[next])
+			next++
+		}
+		if i < t.LeafCount && !dropped[t.names[i]] {
+			leaves = append(leaves, t.leaves[i])
+			names = append(names, t.names[i])
+		}
+	}
+	if len(leaves) == 0 {
+		return errors.New("cannot remove every leaf of a Merkle tree")
+	}
+
+	first := 0
+	for first < min(len(leaves), t.LeafCount) && bytes.Equal(leaves[first], t.leaves[first]) {
+		first++
+	}
+
+	t.leaves = leaves
+	t.names = names
+	t.LeafCount = len(leaves)
+	err := t.indexNames()
+	if err != nil {
+		return err
+	}
+	t.rehashFrom(first)
+	return nil
+}
+
+// rehashFrom rebuilds every node that covers the leaf at index or any leaf
+// after it. The nodes before it can't have changed.
+func (t *MerkleTree) rehashFrom(index int) {
+	scheme := t.scheme()
+	nodes := t.levels[0][:min(index, len(t.levels[0]))]
+	for i := len(nodes); i < len(t.leaves); i++ {
+		nodes = append(nodes, newNode(scheme.hashLeaf(t.leaves[i]), nil, nil))
+	}
+	t.levels[0] = nodes
+
+	position := index
+	level := 0
+	for len(t.levels[level]) > 1 {
+		nodes := t.levels[level]
+		position /= scheme.arity
+		parentCount := scheme.parentCount(len(nodes))
+		if level+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+
+		parents := t.levels[level+1][:min(position, parentCount, len(t.levels[level+1]))]
+		for i := len(parents); i < parentCount; i++ {
+			parents = append(parents, scheme.parent(nodes, i))
+		}
+		t.levels[level+1] = parents
+		level++
+	}
+
+	t.levels = t.levels[:level+1]
+	t.Root = t.levels[level][0]
+}
+
+func (t *MerkleTree) setLeaf(index int, hash []byte) {
+	scheme := t.scheme()
+	t.leaves[index] = hash
+	t.levels[0][index] = newNode(scheme.hashLeaf(hash), nil, nil)
+
+	position := index
+	for level := 0; level < len(t.levels)-1; level++ {
+		position /= scheme.arity
+		t.levels[level+1][position] = scheme.parent(t.levels[level], position)
+	}
+	t.Root = t.levels[len(t.levels)-1][0]
+}
+
+// removeLast drops the last leaf. Only the last node of each level can
+// change, so just that node's parent is rehashed on the way up.
+func (t *MerkleTree) removeLast() {
+	scheme := t.scheme()
+	t.LeafCount--
+	t.leaves = t.leaves[:t.LeafCount]
+	t.levels[0] = t.levels[0][:t.LeafCount]
+
+	level := 0
+	for len(t.levels[level]) > 1 {
+		nodes := t.levels[level]
+		parentCount := scheme.parentCount(len(nodes))
+		t.levels[level+1] = t.levels[level+1][:parentCount]
+		t.levels[level+1][parentCount-1] = scheme.parent(nodes, parentCount-1)
+		level++
+	}
+
+	t.levels = t.levels[:level+1]
+	t.Root = t.levels[level][0]
+}
+
+// checkOrderAround records that the leaves are no longer sorted when the
+// leaf at index is out of order with its neighbours
+func (t *MerkleTree) checkOrderAround(index int) {
+	if t.LeafOrder != LeafOrderSortedHash {
+		return
+	}
+
+	isSorted := (index == 0 || bytes.Compare(t.leaves[index-1], t.leaves[index]) <= 0) &&
+		(index == t.LeafCount-1 || bytes.Compare(t.leaves[index], t.leaves[index+1]) <= 0)
+	if !isSorted {
+		t.LeafOrder = LeafOrderInsertion
+	}
+}
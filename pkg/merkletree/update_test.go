@@ -0,0 +1,311 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testLeaves(size int) ([][]byte, []string) {
+	var leafHashes [][]byte
+	var names []string
+	for i := 0; i < size; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("hash%d", i)))
+		leafHashes = append(leafHashes, hash[:])
+		names = append(names, fmt.Sprintf("%d.txt", i))
+	}
+	return leafHashes, names
+}
+
+func TestUpdate(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+			t.Run(fmt.Sprintf("%d %s", arity, strategy), func(t *testing.T) {
+				for size := 1; size <= 13; size++ {
+					leafHashes, _ := testLeaves(size)
+					tree, err := BuildTree(leafHashes, WithArity(arity), WithOddNodeStrategy(strategy))
+					if err != nil {
+						t.Fatalf("returned unexpected error: %v", err)
+					}
+
+					for index := 0; index < size; index++ {
+						hash := sha256.Sum256([]byte(fmt.Sprintf("updated%d", index)))
+						leafHashes[index] = hash[:]
+						err := tree.Update(index, hash[:])
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+
+						want, err := BuildTree(leafHashes, WithArity(arity), WithOddNodeStrategy(strategy))
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+						if !reflect.DeepEqual(tree.RootHash(), want.RootHash()) {
+							t.Errorf("size %d index %d: got %x, want %x", size, index, tree.RootHash(), want.RootHash())
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+			t.Run(fmt.Sprintf("%d %s", arity, strategy), func(t *testing.T) {
+				for size := 2; size <= 13; size++ {
+					for index := 0; index < size; index++ {
+						leafHashes, _ := testLeaves(size)
+						tree, err := BuildTree(leafHashes, WithArity(arity), WithOddNodeStrategy(strategy))
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+
+						err = tree.Remove(index)
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+
+						// The last leaf takes the removed leaf's place
+						leafHashes[index] = leafHashes[size-1]
+						leafHashes = leafHashes[:size-1]
+						want, err := BuildTree(leafHashes, WithArity(arity), WithOddNodeStrategy(strategy))
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+						if !reflect.DeepEqual(tree.RootHash(), want.RootHash()) {
+							t.Errorf("size %d index %d: got %x, want %x", size, index, tree.RootHash(), want.RootHash())
+						}
+						if tree.LeafCount != size-1 {
+							t.Errorf("got leaf count %d, want %d", tree.LeafCount, size-1)
+						}
+
+						for i, leafHash := range leafHashes {
+							proof, err := tree.CreateProof(i)
+							if err != nil {
+								t.Fatalf("returned unexpected error: %v", err)
+							}
+							if valid, _ := tree.VerifyProof(leafHash, proof); !valid {
+								t.Errorf("size %d index %d: expected true for leaf %d", size, index, i)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRemoveInvalid(t *testing.T) {
+	leafHashes, _ := testLeaves(1)
+	tree, err := BuildTree(leafHashes)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	if err := tree.Remove(0); err == nil {
+		t.Error("expected an error for removing the only leaf")
+	}
+	if err := tree.Remove(1); err == nil {
+		t.Error("expected an error for out of range index")
+	}
+	if err := tree.Update(-1, leafHashes[0]); err == nil {
+		t.Error("expected an error for negative index")
+	}
+}
+
+func TestLeafNames(t *testing.T) {
+	leafHashes, names := testLeaves(6)
+	tree, err := BuildTree(leafHashes, WithLeafNames(names))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	hash := sha256.Sum256([]byte("updated"))
+	err = tree.UpdateByName("3.txt", hash[:])
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if leaf, _ := tree.Leaf(3); !reflect.DeepEqual(leaf, hash[:]) {
+		t.Errorf("got leaf %x, want %x", leaf, hash[:])
+	}
+
+	err = tree.RemoveByName("1.txt")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if _, ok := tree.LeafIndex("1.txt"); ok {
+		t.Error("expected removed name to be gone")
+	}
+	if index, ok := tree.LeafIndex("5.txt"); !ok || index != 1 {
+		t.Errorf("expected the last leaf to move to index 1, got %d", index)
+	}
+
+	err = tree.AppendNamed("6.txt", leafHashes[1])
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if name, _ := tree.LeafName(5); name != "6.txt" {
+		t.Errorf("got name %s, want 6.txt", name)
+	}
+
+	if err := tree.AppendNamed("6.txt", leafHashes[1]); err == nil {
+		t.Error("expected an error for a duplicate name")
+	}
+	if err := tree.UpdateByName("missing.txt", hash[:]); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+	if _, err := BuildTree(leafHashes, WithLeafNames(names[:5])); err == nil {
+		t.Error("expected an error for too few names")
+	}
+	if _, err := BuildTree(leafHashes[:2], WithLeafNames([]string{"a", "a"})); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+}
+
+func TestUpdateLeafOrder(t *testing.T) {
+	leafHashes, _ := testLeaves(5)
+	sort.Slice(leafHashes, func(i int, j int) bool {
+		return bytes.Compare(leafHashes[i], leafHashes[j]) < 0
+	})
+	tree, err := BuildTree(leafHashes, WithLeafOrder(LeafOrderSortedHash))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+
+	// Swapping in a hash that fits between its neighbours keeps the order
+	between := append([]byte{}, leafHashes[2]...)
+	between[len(between)-1]++
+	err = tree.Update(2, between)
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if tree.LeafOrder != LeafOrderSortedHash {
+		t.Errorf("got leaf order %s, want %s", tree.LeafOrder, LeafOrderSortedHash)
+	}
+
+	err = tree.Update(0, leafHashes[4])
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if tree.LeafOrder != LeafOrderInsertion {
+		t.Errorf("got leaf order %s, want %s", tree.LeafOrder, LeafOrderInsertion)
+	}
+}
+
+func TestApplySorted(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote, OddNodeZero} {
+			t.Run(fmt.Sprintf("%d %s", arity, strategy), func(t *testing.T) {
+				for size := 1; size <= 13; size++ {
+					for change := 0; change < size; change++ {
+						leafHashes, names := sortByHashForTest(testLeaves(size))
+						opts := []Option{WithArity(arity), WithOddNodeStrategy(strategy), WithLeafOrder(LeafOrderSortedHash)}
+						tree, err := BuildTree(leafHashes, append(opts, WithLeafNames(names))...)
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+
+						// Modify one leaf, add two and remove another
+						want := make(map[string][]byte, size+2)
+						for i, name := range names {
+							want[name] = leafHashes[i]
+						}
+						set := make(map[string][]byte)
+						for _, name := range []string{names[change], "new1.txt", "new2.txt"} {
+							hash := sha256.Sum256([]byte(fmt.Sprintf("%s %d", name, change)))
+							set[name] = hash[:]
+							want[name] = hash[:]
+						}
+						var remove []string
+						if size > 1 {
+							removed := names[(change+1)%size]
+							remove = append(remove, removed)
+							delete(want, removed)
+						}
+
+						err = tree.ApplySorted(set, remove)
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+
+						var wantHashes [][]byte
+						var wantNames []string
+						for name, hash := range want {
+							wantHashes = append(wantHashes, hash)
+							wantNames = append(wantNames, name)
+						}
+						wantHashes, wantNames = sortByHashForTest(wantHashes, wantNames)
+						wantTree, err := BuildTree(wantHashes, append(opts, WithLeafNames(wantNames))...)
+						if err != nil {
+							t.Fatalf("returned unexpected error: %v", err)
+						}
+						if !reflect.DeepEqual(tree.RootHash(), wantTree.RootHash()) {
+							t.Errorf("size %d change %d: got %x, want %x", size, change, tree.RootHash(), wantTree.RootHash())
+						}
+						if tree.LeafOrder != LeafOrderSortedHash {
+							t.Errorf("got leaf order %s, want %s", tree.LeafOrder, LeafOrderSortedHash)
+						}
+						for i, name := range wantNames {
+							if index, ok := tree.LeafIndex(name); !ok || index != i {
+								t.Errorf("size %d change %d: got index %d for %s, want %d", size, change, index, name, i)
+							}
+							proof, err := tree.CreateProof(i)
+							if err != nil {
+								t.Fatalf("returned unexpected error: %v", err)
+							}
+							if valid, _ := tree.VerifyProof(wantHashes[i], proof); !valid {
+								t.Errorf("size %d change %d: expected true for leaf %d", size, change, i)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestApplySortedInvalid(t *testing.T) {
+	leafHashes, names := testLeaves(2)
+	tree, err := BuildTree(leafHashes, WithLeafNames(names))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if err := tree.ApplySorted(nil, []string{"0.txt"}); err == nil {
+		t.Error("expected an error for a tree that isn't sorted by hash")
+	}
+
+	leafHashes, names = sortByHashForTest(leafHashes, names)
+	tree, err = BuildTree(leafHashes, WithLeafNames(names), WithLeafOrder(LeafOrderSortedHash))
+	if err != nil {
+		t.Fatalf("returned unexpected error: %v", err)
+	}
+	if err := tree.ApplySorted(nil, []string{"missing.txt"}); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+	if err := tree.ApplySorted(nil, names); err == nil {
+		t.Error("expected an error for removing every leaf")
+	}
+}
+
+func sortByHashForTest(hashes [][]byte, names []string) ([][]byte, []string) {
+	order := make([]int, len(hashes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i int, j int) bool {
+		return bytes.Compare(hashes[order[i]], hashes[order[j]]) < 0
+	})
+
+	sortedHashes := make([][]byte, len(hashes))
+	sortedNames := make([]string, len(names))
+	for i, j := range order {
+		sortedHashes[i] = hashes[j]
+		sortedNames[i] = names[j]
+	}
+	return sortedHashes, sortedNames
+}